@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SharedPotd holds everything about today's potd which is the same for every account, so that it is only fetched,
+// downloaded and compressed once however many accounts post it.
+type SharedPotd struct {
+	// Date is the Commons day of the potd.
+	Date time.Time
+	Info ImageInfo
+	// Categories are the visible categories of the file, or nil with CategoriesErr set if they could not be fetched.
+	Categories    []string
+	CategoriesErr error
+	// Images are the compressed files ready to upload.
+	Images    []string
+	Gazetteer Gazetteer
+}
+
+// threadStyle returns how the threads of an account mark their continuations, with custom markers taking
+// precedence over the named style, and where they link to the file description page.
+func (account AccountConfig) threadStyle() (ContinuationStyle, LinkPlacement) {
+	style, ok := continuationStyles[account.Continuation]
+	if !ok {
+		log.WithField("continuation", account.Continuation).Panic("unknown continuation style")
+	}
+	if account.ContinuationPrefix != "" || account.ContinuationSuffix != "" {
+		style = ContinuationStyle{Prefix: account.ContinuationPrefix, Suffix: account.ContinuationSuffix}
+	}
+	placement, ok := linkPlacements[account.FileLink]
+	if !ok {
+		log.WithField("fileLink", account.FileLink).Panic("unknown file link placement")
+	}
+	return style, placement
+}
+
+// runAccount posts the potd for one account, recovering from any panic so that a failure of one account does not
+// stop the others. It returns nil if the account succeeded.
+func runAccount(ctx context.Context, account AccountConfig, potd PotdEntry, shared SharedPotd, history *History) (failure *AccountFailure) {
+	ctx, span := tracer.Start(ctx, "account", trace.WithAttributes(attribute.String("account", account.Name)))
+	defer span.End()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err := panicError(recovered)
+			log.WithError(err).WithField("account", account.Name).Error("could not post potd for account")
+			failure = &AccountFailure{Account: account.Name, Stage: "post", Kind: errorKind(recovered), Error: err.Error()}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			// the history holds the posts of the thread which were made before the failure
+			if entry, ok, _ := history.Get(shared.Date, account.Name); ok {
+				failure.PostIds = entry.PostIds
+			}
+		}
+	}()
+	postForAccount(ctx, account, potd, shared, history)
+	return nil
+}
+
+// postForAccount uploads the images and posts the description of the potd with the settings of an account,
+// unless its filter rejects the potd. Each post is recorded in the history as soon as it is made, and a thread
+// which was interrupted is continued from the last recorded post.
+func postForAccount(ctx context.Context, account AccountConfig, potd PotdEntry, shared SharedPotd, history *History) {
+	logger := log.WithField("account", account.Name)
+
+	checkpoint, _, err := history.Get(shared.Date, account.Name)
+	if err != nil {
+		logger.WithError(err).Panic("could not read history")
+	}
+	if checkpoint.FileName != potd.FileName {
+		if len(checkpoint.PostIds) > 0 {
+			logger.WithField("fileName", checkpoint.FileName).Warn("abandoning interrupted thread about another file")
+		}
+		checkpoint = HistoryEntry{Date: shared.Date.Format(dateLayout), Account: account.Name, FileName: potd.FileName}
+	}
+	record := func() {
+		if err := history.Record(checkpoint); err != nil {
+			logger.WithError(err).WithField("entry", checkpoint).Panic("could not record post in history, so it may be posted again")
+		}
+	}
+
+	filter := account.Filter
+	if shared.CategoriesErr != nil && (len(filter.Categories) > 0 || len(filter.ExcludeCategories) > 0) {
+		logger.WithError(shared.CategoriesErr).Panic("could not fetch categories of file to filter it")
+	}
+	if potd.MediaInfoErr != nil && len(filter.Depicts) > 0 {
+		logger.WithError(potd.MediaInfoErr).Panic("could not fetch structured data of file to filter it")
+	}
+	if reason := filter.Match(shared.Info, shared.Categories, potd.Depicts); reason != "" {
+		logger.WithField("reason", reason).Info("potd does not pass the filter of account, skipping it")
+		checkpoint.Skipped, checkpoint.Complete = reason, true
+		record()
+		health.succeeded(time.Now())
+		return
+	}
+
+	style, placement := account.threadStyle()
+	tmpl, err := parsePostTemplate("twitter", account.Twitter.Template)
+	if err != nil {
+		logger.WithError(err).Panic("invalid post template")
+	}
+
+	hashtags := generateHashtags(shared.Categories, potd.Depicts, HashtagOptions{
+		MaxCount:  account.Hashtags,
+		MaxWords:  3,
+		Blocklist: account.HashtagBlocklist,
+	})
+	place := ""
+	if potd.Coordinates != nil {
+		if nearest, ok := shared.Gazetteer.Nearest(*potd.Coordinates, account.PlaceMaxDistance); ok {
+			place = nearest.String()
+		}
+		logger.WithFields(log.Fields{"coordinates": potd.Coordinates.String(), "place": place}).Info("looked up place of file")
+	}
+	postText, err := composePost(tmpl, PostData{
+		PotdEntry:  potd,
+		Date:       shared.Date,
+		Author:     shared.Info.Artist,
+		Licence:    shared.Info.Licence,
+		LicenceUrl: shared.Info.LicenceUrl,
+		Language:   account.Language,
+		Place:      place,
+		Hashtags:   hashtags,
+	})
+	if err != nil {
+		logger.WithError(err).Panic("could not compose post from template")
+	}
+	// generate batch of tweets to send out
+	tweetsBatch := SplitThreadWithLink(postText, style, TwitterRule, potd.FilePageUrl, placement)
+
+	if len(tweetsBatch) >= 100 {
+		logger.WithFields(log.Fields{"postText": postText, "tweetCount": len(tweetsBatch), "tweets": tweetsBatch}).Panic("too many tweets generated from description")
+	}
+
+	// this Client will automatically authorize any requests to the Twitter API
+	httpClient := getAuthorisedClient(account.Twitter)
+	logger.Info("created http client")
+
+	if len(checkpoint.PostIds) > 0 {
+		logger.WithField("postIds", checkpoint.PostIds).Info("resuming interrupted thread")
+	}
+	postThread(ctx, httpClient, tweetsBatch, shared.Images, checkpoint.PostIds, func(postIds []string) {
+		checkpoint.PostIds = postIds
+		record()
+	})
+
+	checkpoint.Complete = true
+	record()
+	lastPostTimestamp.WithLabelValues("twitter", account.Name).SetToCurrentTime()
+	threadLength.WithLabelValues("twitter", account.Name).Set(float64(len(tweetsBatch)))
+	health.succeeded(time.Now())
+	logger.Info("done posting tweets")
+}
+
+// postThread posts a thread of tweets, the first with the images attached, and returns the ids of the tweets.
+// Posting continues after postIds, the tweets of the thread which have already been posted, if there are any.
+// The posted function is called with the ids so far as soon as each tweet is posted. Cancelling ctx aborts the upload
+// or post in flight, by which time posted has been called for every tweet which Twitter confirmed.
+func postThread(ctx context.Context, httpClient *http.Client, tweets []string, images []string, postIds []string, posted func([]string)) []string {
+	if len(postIds) == 0 {
+		var mediaIds []string
+		for _, image := range images {
+			mediaIds = append(mediaIds, uploadImage(ctx, httpClient, image))
+		}
+		log.WithField("count", len(mediaIds)).Info("potd images uploaded")
+
+		// post initial tweet with image
+		id := postTweetWithImage(ctx, httpClient, tweets[0], mediaIds)
+		log.WithField("id", id).Info("tweet posted with media")
+		postIds = append(postIds, id)
+		posted(postIds)
+	}
+
+	// post each of the remaining tweets
+	for len(postIds) < len(tweets) {
+		id := postTweetInReply(ctx, httpClient, tweets[len(postIds)], postIds[len(postIds)-1])
+		log.WithField("id", id).Info("tweet posted in reply to previous tweet")
+		postIds = append(postIds, id)
+		posted(postIds)
+	}
+	return postIds
+}
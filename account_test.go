@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends every request to the test server instead of the host it was made to.
+type redirectTransport struct {
+	server *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.server.Scheme, t.server.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestPostThreadCancelled(t *testing.T) {
+	tweets := 0
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/media/upload.json"):
+			w.Write([]byte(`{"media_id": 7}`))
+		case tweets == 0:
+			tweets++
+			w.Write([]byte(`{"data": {"id": "100"}}`))
+		default:
+			// the reply hangs until the test ends, so the client has to give up on it
+			<-release
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	serverUrl, _ := url.Parse(server.URL)
+	httpClient := &http.Client{Transport: redirectTransport{server: serverUrl}}
+
+	image := filepath.Join(t.TempDir(), "potd.jpg")
+	if err := os.WriteFile(image, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	var recorded []string
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		postThread(ctx, httpClient, []string{"first", "second"}, []string{image}, nil, func(postIds []string) {
+			recorded = append([]string{}, postIds...)
+		})
+	}()
+
+	if recovered == nil {
+		t.Fatal("expected posting to be aborted")
+	}
+	if kind := errorKind(recovered); kind != "timeout" {
+		t.Errorf("expected a timeout, got %q: %v", kind, panicError(recovered))
+	}
+	if strings.Join(recorded, ",") != "100" {
+		t.Errorf("expected only the confirmed tweet to be recorded, got %v", recorded)
+	}
+}
+
+// Test that an account which filters on depicted items fails, rather than skipping the day for good, when the
+// structured data could not be fetched.
+func TestPostForAccountWithoutMediaInfo(t *testing.T) {
+	history := &History{Path: filepath.Join(t.TempDir(), "history.jsonl")}
+	account := AccountConfig{Name: "birds", Filter: FilterConfig{Depicts: []string{"Q5113"}}}
+	potd := PotdEntry{FileName: "Example.jpg", MediaInfoErr: errors.New("wikidata is down")}
+	shared := SharedPotd{Date: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+
+	assertPanics(t, "depicts filter without structured data", func() {
+		postForAccount(context.Background(), account, potd, shared, history)
+	})
+	if _, ok, err := history.Get(shared.Date, account.Name); ok || err != nil {
+		t.Errorf("expected nothing to be recorded, so that the day is retried (err %v)", err)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Alert is sent to every configured channel when a run fails, and again as a recovery notice when a run next
+// succeeds. A recovery notice only has Recovered, Summary, Date, RunId and Time set. RunId is that of the log
+// entries of the run.
+type Alert struct {
+	Recovered bool   `json:"recovered"`
+	Summary   string `json:"summary"`
+	Date      string `json:"date"`
+	RunId     string `json:"runId"`
+	Stage     string `json:"stage,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Potd is the potd which was being posted, if it was fetched before the failure.
+	Potd *PotdEntry `json:"potd,omitempty"`
+	// Accounts are the accounts which could not post the potd, with the posts of their threads made so far.
+	Accounts []AccountFailure `json:"accounts,omitempty"`
+	Time     time.Time        `json:"time"`
+}
+
+// newAlert describes a failed run, or the recovery of the program if err is nil.
+func newAlert(date time.Time, err error) Alert {
+	alert := Alert{Date: date.Format(dateLayout), RunId: runId.get(), Time: time.Now().UTC()}
+	if err == nil {
+		alert.Recovered = true
+		alert.Summary = fmt.Sprintf("wikicommonspotd recovered: the potd of %s was posted", alert.Date)
+		return alert
+	}
+
+	alert.Stage, alert.Kind, alert.Error = "run", "internal", err.Error()
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		alert.Stage, alert.Kind, alert.Error = runErr.Stage, runErr.Kind, runErr.Err.Error()
+		alert.Potd, alert.Accounts = runErr.Potd, runErr.Accounts
+	}
+	alert.Summary = fmt.Sprintf("wikicommonspotd failed to post the potd of %s at the %s stage (%s)", alert.Date, alert.Stage, alert.Kind)
+	return alert
+}
+
+// Text returns the alert as plain text, for channels which do not take JSON.
+func (alert Alert) Text() string {
+	var text strings.Builder
+	fmt.Fprintln(&text, alert.Summary)
+	if alert.Recovered {
+		return text.String()
+	}
+	fmt.Fprintf(&text, "\nError: %s\nRun: %s\n", alert.Error, alert.RunId)
+	if alert.Potd != nil {
+		fmt.Fprintf(&text, "File: %s\n%s\n", alert.Potd.FileName, alert.Potd.FilePageUrl)
+	}
+	for _, account := range alert.Accounts {
+		fmt.Fprintf(&text, "\nAccount %s (%s at the %s stage): %s\n", account.Account, account.Kind, account.Stage, account.Error)
+		if len(account.PostIds) > 0 {
+			fmt.Fprintf(&text, "Posted so far: %s\n", strings.Join(account.PostIds, ", "))
+		}
+	}
+	return text.String()
+}
+
+// alertState is kept in the state file between runs, so that a recovery notice can follow a failure in an earlier
+// process, and the same failure is not alerted on every retry.
+type alertState struct {
+	Failing bool `json:"failing"`
+	// Key identifies the last failure alerted on.
+	Key string `json:"key,omitempty"`
+}
+
+// alertKey identifies a failure, so that repeated retries which fail in the same way only alert once.
+func alertKey(alert Alert) string {
+	return alert.Date + " " + alert.Stage + " " + alert.Kind
+}
+
+func readAlertState(path string) (alertState, error) {
+	var state alertState
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	return state, json.Unmarshal(buf, &state)
+}
+
+func writeAlertState(path string, state alertState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf, 0644)
+}
+
+// Enabled reports whether any alert channel is configured.
+func (alerts AlertsConfig) Enabled() bool {
+	return alerts.WebhookUrl != "" || alerts.NtfyUrl != "" || alerts.SmtpAddress != ""
+}
+
+// notifyOutcome alerts on the outcome of a run of the given day: a failure which was not already alerted on, or a
+// success following a failure. Alerts which cannot be sent are only logged, since there is nobody else to tell.
+func notifyOutcome(alerts AlertsConfig, date time.Time, runErr error) {
+	if !alerts.Enabled() {
+		return
+	}
+	state := alertState{}
+	if alerts.StateFile != "" {
+		var err error
+		if state, err = readAlertState(alerts.StateFile); err != nil {
+			log.WithError(err).WithField("path", alerts.StateFile).Warn("could not read alert state")
+		}
+	}
+
+	alert := newAlert(date, runErr)
+	switch {
+	case alert.Recovered && !state.Failing:
+		return
+	case !alert.Recovered && state.Failing && state.Key == alertKey(alert):
+		log.WithField("key", state.Key).Info("already alerted on this failure")
+		return
+	}
+	sendAlert(alerts, alert)
+
+	state = alertState{Failing: !alert.Recovered}
+	if !alert.Recovered {
+		state.Key = alertKey(alert)
+	}
+	if alerts.StateFile != "" {
+		if err := writeAlertState(alerts.StateFile, state); err != nil {
+			log.WithError(err).WithField("path", alerts.StateFile).Warn("could not write alert state")
+		}
+	}
+}
+
+// runWithAlerts runs the pipeline for the given day like runOnce, alerting on its outcome. A run which failed because
+// ctx was cancelled, such as by a signal to stop, is not alerted on.
+func runWithAlerts(ctx context.Context, config Config, history *History, date time.Time) error {
+	err := runOnce(ctx, config, history, date)
+	if err != nil && ctx.Err() != nil {
+		log.WithError(err).Info("run was cancelled, not alerting")
+		return err
+	}
+	notifyOutcome(config.Alerts, date, err)
+	return err
+}
+
+// sendAlert sends the alert to every configured channel.
+func sendAlert(alerts AlertsConfig, alert Alert) {
+	channels := map[string]func(context.Context, AlertsConfig, Alert) error{}
+	if alerts.WebhookUrl != "" {
+		channels["webhook"] = sendWebhookAlert
+	}
+	if alerts.NtfyUrl != "" {
+		channels["ntfy"] = sendNtfyAlert
+	}
+	if alerts.SmtpAddress != "" {
+		channels["smtp"] = sendEmailAlert
+	}
+	for name, send := range channels {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(alerts.Timeout))
+		err := send(ctx, alerts, alert)
+		cancel()
+		if err != nil {
+			log.WithError(err).WithField("channel", name).Error("could not send alert")
+			continue
+		}
+		log.WithFields(log.Fields{"channel": name, "summary": alert.Summary}).Info("sent alert")
+	}
+}
+
+// postAlert posts the body to the url, and checks that it was accepted.
+func postAlert(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("bad http status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendWebhookAlert posts the alert as JSON.
+func sendWebhookAlert(ctx context.Context, alerts AlertsConfig, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, alerts.WebhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return postAlert(req)
+}
+
+// sendNtfyAlert pushes the alert to an ntfy topic, with the summary as its title.
+func sendNtfyAlert(ctx context.Context, alerts AlertsConfig, alert Alert) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, alerts.NtfyUrl, strings.NewReader(alert.Text()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", alert.Summary)
+	if alert.Recovered {
+		req.Header.Set("Tags", "white_check_mark")
+	} else {
+		req.Header.Set("Tags", "warning")
+		req.Header.Set("Priority", "high")
+	}
+	if alerts.NtfyToken != "" {
+		req.Header.Set("Authorization", "Bearer "+alerts.NtfyToken)
+	}
+	return postAlert(req)
+}
+
+// sendEmailAlert emails the alert as plain text, as smtp.SendMail would but within the deadline of ctx. The server
+// must offer STARTTLS for a password to be sent, unless it is on the local machine.
+func sendEmailAlert(ctx context.Context, alerts AlertsConfig, alert Alert) error {
+	host, _, err := net.SplitHostPort(alerts.SmtpAddress)
+	if err != nil {
+		return err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", alerts.SmtpFrom)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(alerts.SmtpTo, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", alert.Summary)
+	fmt.Fprintf(&message, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(alert.Text(), "\n", "\r\n"))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", alerts.SmtpAddress)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if alerts.SmtpUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", alerts.SmtpUsername, alerts.SmtpPassword, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(alerts.SmtpFrom); err != nil {
+		return err
+	}
+	for _, to := range alerts.SmtpTo {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(message.Bytes()); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
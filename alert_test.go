@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSmtpServer accepts one message at a time without authentication, sending the data of each to messages.
+func fakeSmtpServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			io.WriteString(conn, "220 localhost ESMTP\r\n")
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				command := strings.ToUpper(strings.TrimSpace(line))
+				switch {
+				case strings.HasPrefix(command, "DATA"):
+					io.WriteString(conn, "354 go ahead\r\n")
+					var data strings.Builder
+					for {
+						line, err := reader.ReadString('\n')
+						if err != nil || line == ".\r\n" {
+							break
+						}
+						data.WriteString(line)
+					}
+					messages <- data.String()
+					io.WriteString(conn, "250 queued\r\n")
+				case strings.HasPrefix(command, "QUIT"):
+					io.WriteString(conn, "221 bye\r\n")
+				default:
+					io.WriteString(conn, "250 ok\r\n")
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func TestNotifyOutcome(t *testing.T) {
+	var webhookAlerts []Alert
+	var ntfyTitles []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/webhook":
+			var alert Alert
+			if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+				t.Error(err)
+			}
+			webhookAlerts = append(webhookAlerts, alert)
+		case "/ntfy":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				t.Errorf("expected the ntfy token, got %q", r.Header.Get("Authorization"))
+			}
+			ntfyTitles = append(ntfyTitles, r.Header.Get("Title"))
+		}
+	}))
+	defer server.Close()
+	smtpAddress, messages := fakeSmtpServer(t)
+
+	alerts := AlertsConfig{
+		StateFile:   filepath.Join(t.TempDir(), "alerts.json"),
+		WebhookUrl:  server.URL + "/webhook",
+		NtfyUrl:     server.URL + "/ntfy",
+		NtfyToken:   "token",
+		SmtpAddress: smtpAddress,
+		SmtpFrom:    "bot@example.org",
+		SmtpTo:      []string{"owner@example.org"},
+		Timeout:     Duration(5 * time.Second),
+	}
+	date := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	runErr := &RunError{
+		Date:     date,
+		Stage:    "post",
+		Kind:     "http",
+		Potd:     &PotdEntry{FileName: "File:Example.jpg"},
+		Accounts: []AccountFailure{{Account: "default", Stage: "post", Kind: "http", Error: "rate limited", PostIds: []string{"1", "2"}}},
+		Err:      errors.New("could not post potd for accounts [default]"),
+	}
+
+	notifyOutcome(alerts, date, runErr)
+	// a retry which fails in the same way is not alerted on again
+	notifyOutcome(alerts, date, runErr)
+	notifyOutcome(alerts, date, nil)
+	// nor is a success which follows a success
+	notifyOutcome(alerts, date, nil)
+
+	if len(webhookAlerts) != 2 || len(ntfyTitles) != 2 {
+		t.Fatalf("expected a failure and a recovery alert, got %d webhook and %d ntfy alerts", len(webhookAlerts), len(ntfyTitles))
+	}
+	failure := webhookAlerts[0]
+	if failure.Recovered || failure.Stage != "post" || failure.Kind != "http" || failure.Potd == nil || failure.Potd.FileName != "File:Example.jpg" {
+		t.Errorf("unexpected failure alert %+v", failure)
+	}
+	if len(failure.Accounts) != 1 || strings.Join(failure.Accounts[0].PostIds, ",") != "1,2" {
+		t.Errorf("expected the partial thread in the alert, got %+v", failure.Accounts)
+	}
+	if !webhookAlerts[1].Recovered {
+		t.Errorf("expected a recovery alert, got %+v", webhookAlerts[1])
+	}
+
+	emails := [][]string{
+		{"Subject: " + failure.Summary, "Posted so far: 1, 2"},
+		{"Subject: " + webhookAlerts[1].Summary},
+	}
+	for _, expected := range emails {
+		select {
+		case message := <-messages:
+			for _, text := range expected {
+				if !strings.Contains(message, text) {
+					t.Errorf("expected email to contain %q, got %q", text, message)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for email")
+		}
+	}
+}
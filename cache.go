@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ImageCache is a content-addressed directory of downloaded and compressed potd images.
+// Images are keyed by the SHA-1 Commons reports for the original file, so a file uploaded again gets new entries,
+// and the least recently used entries are evicted once the total size exceeds MaxBytes.
+// The modification time of each file records when it was last used.
+type ImageCache struct {
+	Dir      string
+	MaxBytes int64
+}
+
+// Enabled reports whether the cache is in use.
+func (c *ImageCache) Enabled() bool {
+	return c != nil && c.Dir != "" && c.MaxBytes > 0
+}
+
+// Get returns the path of the cached file with the given key, marking it as recently used.
+// Cached files must not be modified or removed by the caller.
+func (c *ImageCache) Get(key string) (string, bool) {
+	if !c.Enabled() {
+		return "", false
+	}
+	path := filepath.Join(c.Dir, key)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// Put copies the file at srcPath into the cache under the given key, evicts old entries if necessary,
+// and returns the path of the cached copy. If the cache is disabled, srcPath is returned.
+func (c *ImageCache) Put(key string, srcPath string) string {
+	if !c.Enabled() {
+		return srcPath
+	}
+	path := c.store(key, srcPath)
+	c.evict(key)
+	return path
+}
+
+// incomingPrefix starts the names of the temporary files which are being copied into the cache.
+const incomingPrefix = ".incoming-"
+
+// store copies the file at srcPath into the cache under the given key without evicting anything, and returns the
+// path of the cached copy.
+func (c *ImageCache) store(key string, srcPath string) string {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		log.WithError(err).WithField("dir", c.Dir).Panic("could not create cache directory")
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		log.WithError(err).WithField("path", srcPath).Panic("could not open file to cache")
+	}
+	defer src.Close()
+
+	// write to a temporary file first, so that an interrupted run never leaves a truncated entry
+	tmp, err := os.CreateTemp(c.Dir, incomingPrefix+"*")
+	if err != nil {
+		log.WithError(err).WithField("dir", c.Dir).Panic("could not create file in cache directory")
+	}
+	_, err = io.Copy(tmp, src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.WithError(err).WithField("key", key).Panic("could not copy file into cache")
+	}
+
+	path := filepath.Join(c.Dir, key)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		log.WithError(err).WithField("key", key).Panic("could not move file into cache")
+	}
+	log.WithFields(log.Fields{"key": key, "path": path}).Info("stored file in cache")
+	return path
+}
+
+// evict removes the least recently used entries until the cache fits within its size limit.
+// The entries with the given keys are kept regardless, so that a single oversized file or set can still be used.
+// Files still being copied in are neither counted nor removed.
+func (c *ImageCache) evict(keep ...string) {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		log.WithError(err).WithField("dir", c.Dir).Warn("could not list cache directory for eviction")
+		return
+	}
+
+	type cachedFile struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cachedFile
+	var total int64
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), incomingPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, cachedFile{name: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, file := range files {
+		if total <= c.MaxBytes {
+			break
+		}
+		if slices.Contains(keep, file.name) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, file.name)); err != nil {
+			log.WithError(err).WithField("name", file.name).Warn("could not evict cache entry")
+			continue
+		}
+		total -= file.size
+		log.WithFields(log.Fields{"name": file.name, "size": file.size}).Info("evicted cache entry")
+	}
+}
+
+// GetSet returns the paths of a group of files stored with PutSet, or false unless every one of them is present.
+func (c *ImageCache) GetSet(key string) ([]string, bool) {
+	if !c.Enabled() {
+		return nil, false
+	}
+	matches, err := filepath.Glob(filepath.Join(c.Dir, key+"-*of*"))
+	if err != nil || len(matches) == 0 {
+		return nil, false
+	}
+
+	var paths []string
+	for i := 0; ; i++ {
+		var found string
+		for _, match := range matches {
+			var index, count int
+			var extension string
+			_, err := fmt.Sscanf(filepath.Base(match)[len(key):], "-%dof%d%s", &index, &count, &extension)
+			if err == nil && index == i && count == len(matches) {
+				found = filepath.Base(match)
+			}
+		}
+		if found == "" {
+			return nil, false
+		}
+		path, ok := c.Get(found)
+		if !ok {
+			return nil, false
+		}
+		paths = append(paths, path)
+		if len(paths) == len(matches) {
+			return paths, true
+		}
+	}
+}
+
+// PutSet stores a group of files under one key, keeping their order and extensions.
+// If the cache is disabled, srcPaths is returned.
+func (c *ImageCache) PutSet(key string, srcPaths []string) []string {
+	if !c.Enabled() {
+		return srcPaths
+	}
+	// the set is only evicted once it is complete, so that storing one member cannot evict another
+	var keys, paths []string
+	for i, srcPath := range srcPaths {
+		memberKey := fmt.Sprintf("%s-%dof%d%s", key, i, len(srcPaths), filepath.Ext(srcPath))
+		keys = append(keys, memberKey)
+		paths = append(paths, c.store(memberKey, srcPath))
+	}
+	c.evict(keys...)
+	return paths
+}
+
+// optionsKey derives a short cache key component from the settings which affect compressed output.
+func optionsKey(settings ...interface{}) string {
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		log.WithError(err).Panic("could not encode settings for cache key")
+	}
+	digest := sha1.Sum(encoded)
+	return hex.EncodeToString(digest[:8])
+}
+
+// cachedImageInfo returns the imageinfo of a file, which is cached so that cached images can be used offline.
+// It is always requested from Commons first, since the file may have been uploaded again with different content
+// or metadata, and the cached entry is only used when the request fails.
+func cachedImageInfo(ctx context.Context, cache *ImageCache, fileName string) ImageInfo {
+	digest := sha1.Sum([]byte(fileName))
+	key := "imageinfo-" + hex.EncodeToString(digest[:]) + ".json"
+
+	var recovered interface{}
+	info := func() ImageInfo {
+		defer func() { recovered = recover() }()
+		return getImageInfo(ctx, fileName)
+	}()
+	if recovered != nil {
+		// a cancelled run must still stop, rather than carry on with the cached entry
+		cached, ok := readCachedImageInfo(cache, key)
+		if !ok || ctx.Err() != nil {
+			panic(recovered)
+		}
+		log.WithError(panicError(recovered)).WithField("fileName", fileName).Warn("could not fetch imageinfo, using cached imageinfo")
+		return cached
+	}
+
+	if cache.Enabled() {
+		tmp, err := os.CreateTemp("", "imageinfo")
+		if err != nil {
+			log.WithError(err).Panic("could not create temporary file for imageinfo")
+		}
+		defer os.Remove(tmp.Name())
+		err = json.NewEncoder(tmp).Encode(info)
+		tmp.Close()
+		if err != nil {
+			log.WithError(err).Panic("could not write imageinfo to temporary file")
+		}
+		cache.Put(key, tmp.Name())
+	}
+	return info
+}
+
+// readCachedImageInfo returns the imageinfo cached under the given key, if there is a usable entry.
+func readCachedImageInfo(cache *ImageCache, key string) (ImageInfo, bool) {
+	var info ImageInfo
+	path, ok := cache.Get(key)
+	if !ok {
+		return info, false
+	}
+	buf, err := os.ReadFile(path)
+	// entries cached by earlier versions lack the size of the picture, which filters need
+	if err != nil || json.Unmarshal(buf, &info) != nil || info.Width == 0 {
+		log.WithField("path", path).Warn("could not read cached imageinfo")
+		return info, false
+	}
+	return info, true
+}
+
+// cachedFeedHtml returns the description of the potd of the given day in the given language from the feed, which is
+// cached so that the potd can still be posted while the feed cannot be reached. As with imageinfo, the feed is
+// fetched again for every run, and the cached entry is only used if that fails.
+func cachedFeedHtml(ctx context.Context, cache *ImageCache, feedUrl string, language string, date time.Time) string {
+	digest := sha1.Sum([]byte(date.Format(dateLayout) + "|" + language))
+	key := "feed-" + hex.EncodeToString(digest[:]) + ".html"
+
+	var recovered interface{}
+	html := func() string {
+		defer func() { recovered = recover() }()
+		return getHtmlFromFeed(ctx, feedUrl, language, date)
+	}()
+	if recovered != nil {
+		path, ok := cache.Get(key)
+		if !ok || ctx.Err() != nil {
+			panic(recovered)
+		}
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			log.WithError(err).WithField("path", path).Warn("could not read cached feed item")
+			panic(recovered)
+		}
+		log.WithError(panicError(recovered)).WithFields(log.Fields{"language": language, "date": date.Format(dateLayout)}).Warn("could not fetch feed, using cached feed item")
+		return string(buf)
+	}
+
+	if cache.Enabled() {
+		tmp, err := os.CreateTemp("", "feed")
+		if err != nil {
+			log.WithError(err).Panic("could not create temporary file for feed item")
+		}
+		defer os.Remove(tmp.Name())
+		_, err = tmp.WriteString(html)
+		tmp.Close()
+		if err != nil {
+			log.WithError(err).Panic("could not write feed item to temporary file")
+		}
+		cache.Put(key, tmp.Name())
+	}
+	return html
+}
+
+// downloadCached returns the path of the file at the url, downloading it only if it is not cached under the given key.
+// The returned function removes any temporary file which was created, and must be called once the file is no longer needed.
+func downloadCached(ctx context.Context, cache *ImageCache, key string, url string, opts DownloadOptions) (string, func()) {
+	if path, ok := cache.Get(key); ok {
+		log.WithFields(log.Fields{"key": key, "path": path}).Info("using cached potd image")
+		return path, func() {}
+	}
+
+	// create unique temporary file which will be overwritten by the potd image
+	tempFile, err := os.CreateTemp("", "potdFile")
+	if err != nil {
+		log.WithError(err).Panic("failed to create temporary file")
+	}
+	log.WithField("path", tempFile.Name()).Info("created temporary file")
+
+	downloadFile(ctx, tempFile, url, opts)
+	log.WithFields(log.Fields{
+		"url":         url,
+		"destination": tempFile.Name(),
+	}).Info("downloaded potd image")
+
+	err = tempFile.Close()
+	if err != nil {
+		log.WithError(err).Panic("could not close potd image file")
+	}
+
+	if !cache.Enabled() {
+		return tempFile.Name(), func() { os.Remove(tempFile.Name()) }
+	}
+	path := cache.Put(key, tempFile.Name())
+	os.Remove(tempFile.Name())
+	return path, func() {}
+}
+
+// prepareImagesCached returns the output of prepareImages, which is only run if it is not cached under the given key.
+// The returned function removes any temporary files which were created, and must be called once they are no longer needed.
+func prepareImagesCached(ctx context.Context, cache *ImageCache, key string, path string, opts CompressOptions, panorama PanoramaOptions) ([]string, func()) {
+	// the output depends on the backend and every setting, as well as on the original file
+	key += "-" + optionsKey(newImageBackend().Name(), opts, panorama)
+	if paths, ok := cache.GetSet(key); ok {
+		log.WithFields(log.Fields{"key": key, "paths": paths}).Info("using cached compressed images")
+		return paths, func() {}
+	}
+
+	paths := prepareImages(ctx, path, opts, panorama)
+	cleanup := func() {
+		// compressFile may return the original path unchanged, which is not ours to remove
+		for _, compressedPath := range paths {
+			if compressedPath != path {
+				os.Remove(compressedPath)
+			}
+		}
+	}
+	if !cache.Enabled() {
+		return paths, cleanup
+	}
+	cachedPaths := cache.PutSet(key, paths)
+	cleanup()
+	return cachedPaths, func() {}
+}
+
+// defaultCacheDir returns the per-user cache directory for this program, or an empty string (disabling the cache) if there is none.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "wikicommonspotd")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTempFile(t *testing.T, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file.jpeg")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Test that the least recently used entries are evicted first once the cache is over its limit.
+func TestImageCacheEviction(t *testing.T) {
+	cache := &ImageCache{Dir: t.TempDir(), MaxBytes: 250}
+	content := bytes.Repeat([]byte("x"), 100)
+
+	cache.Put("a", writeTempFile(t, content))
+	cache.Put("b", writeTempFile(t, content))
+	// make the entries distinguishable by modification time, then use "a" so that "b" is the oldest
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(cache.Dir, "a"), old, old)
+	os.Chtimes(filepath.Join(cache.Dir, "b"), old.Add(-time.Minute), old.Add(-time.Minute))
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	cache.Put("c", writeTempFile(t, content))
+	if _, ok := cache.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		path, ok := cache.Get(key)
+		if !ok {
+			t.Errorf("expected %s to be kept", key)
+			continue
+		}
+		if got, _ := os.ReadFile(path); !bytes.Equal(got, content) {
+			t.Errorf("unexpected content for %s", key)
+		}
+	}
+}
+
+func TestImageCacheSet(t *testing.T) {
+	cache := &ImageCache{Dir: t.TempDir(), MaxBytes: 1000000}
+	first := writeTempFile(t, []byte("first"))
+	second := filepath.Join(t.TempDir(), "second.png")
+	os.WriteFile(second, []byte("second"), 0644)
+
+	if _, ok := cache.GetSet("key"); ok {
+		t.Fatal("expected empty cache to miss")
+	}
+	cache.PutSet("key", []string{first, second})
+	paths, ok := cache.GetSet("key")
+	if !ok || len(paths) != 2 || filepath.Ext(paths[0]) != ".jpeg" || filepath.Ext(paths[1]) != ".png" {
+		t.Fatalf("expected both files in order, got %v", paths)
+	}
+
+	// a set with a missing member is not returned
+	os.Remove(paths[1])
+	if _, ok := cache.GetSet("key"); ok {
+		t.Error("expected incomplete set to miss")
+	}
+}
+
+// Test that storing a set cannot evict its own members, and that files being copied in are left alone.
+func TestImageCacheSetEviction(t *testing.T) {
+	cache := &ImageCache{Dir: t.TempDir(), MaxBytes: 150}
+	content := bytes.Repeat([]byte("x"), 100)
+	incoming := filepath.Join(cache.Dir, incomingPrefix+"other")
+	if err := os.WriteFile(incoming, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache.Put("old", writeTempFile(t, content))
+
+	cache.PutSet("set", []string{writeTempFile(t, content), writeTempFile(t, content), writeTempFile(t, content)})
+	if paths, ok := cache.GetSet("set"); !ok || len(paths) != 3 {
+		t.Errorf("expected the whole set to be kept, got %v", paths)
+	}
+	if _, ok := cache.Get("old"); ok {
+		t.Error("expected the older entry to be evicted")
+	}
+	if _, err := os.Stat(incoming); err != nil {
+		t.Errorf("expected the file being copied in to be kept: %v", err)
+	}
+}
+
+// Test that a cached download is served without any request.
+func TestDownloadCached(t *testing.T) {
+	requests := 0
+	content := []byte("picture of the day")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	cache := &ImageCache{Dir: t.TempDir(), MaxBytes: 1000000}
+	opts := DownloadOptions{Timeout: time.Minute, MaxBytes: 1000, MaxAttempts: 1}
+	for i := 0; i < 2; i++ {
+		path, cleanup := downloadCached(context.Background(), cache, "sha", server.URL, opts)
+		got, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(got, content) {
+			t.Errorf("unexpected content %q (err %v)", got, err)
+		}
+		cleanup()
+	}
+	if requests != 1 {
+		t.Errorf("expected one request, got %d", requests)
+	}
+}
+
+// Test that imageinfo is fetched again for every run, so that a file uploaded again is noticed, and that the cached
+// entry is only used when Commons cannot be reached.
+func TestCachedImageInfo(t *testing.T) {
+	sha1s := []string{"1111111111111111111111111111111111111111", "2222222222222222222222222222222222222222"}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > len(sha1s) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"query":{"pages":{"1":{"pageid":1,"imageinfo":[{"url":"https://upload.wikimedia.org/Example.jpg","width":640,"height":480,"sha1":%q}]}}}}`, sha1s[requests-1])
+	}))
+	defer server.Close()
+	defer func(previous string) { commonsApiUrl = previous }(commonsApiUrl)
+	commonsApiUrl = server.URL
+
+	cache := &ImageCache{Dir: t.TempDir(), MaxBytes: 1000000}
+	for i, expected := range []string{sha1s[0], sha1s[1], sha1s[1]} {
+		if info := cachedImageInfo(context.Background(), cache, "Example.jpg"); info.Sha1 != expected {
+			t.Errorf("request %d: expected SHA-1 %s, got %s", i+1, expected, info.Sha1)
+		}
+	}
+
+	assertPanics(t, "imageinfo neither fetched nor cached", func() {
+		cachedImageInfo(context.Background(), &ImageCache{Dir: t.TempDir(), MaxBytes: 1000000}, "Example.jpg")
+	})
+}
+
+// Test that the feed is fetched again for every run, and that the cached item of the day and language is only used
+// when the feed cannot be reached.
+func TestCachedFeedHtml(t *testing.T) {
+	available := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Picture of the day</title>`+
+			`<item><title>1 March</title><pubDate>Fri, 01 Mar 2024 00:00:00 GMT</pubDate><description>&lt;p&gt;%s&lt;/p&gt;</description></item>`+
+			`</channel></rss>`, r.URL.Query().Get("language"))
+	}))
+	defer server.Close()
+
+	cache := &ImageCache{Dir: t.TempDir(), MaxBytes: 1000000}
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if got := cachedFeedHtml(context.Background(), cache, server.URL, "de", date); got != "<p>de</p>" {
+		t.Errorf("unexpected html %q", got)
+	}
+
+	available = false
+	if got := cachedFeedHtml(context.Background(), cache, server.URL, "de", date); got != "<p>de</p>" {
+		t.Errorf("expected the cached item while the feed is unavailable, got %q", got)
+	}
+	assertPanics(t, "feed item in another language neither fetched nor cached", func() {
+		cachedFeedHtml(context.Background(), cache, server.URL, "fr", date)
+	})
+	assertPanics(t, "feed item of another day neither fetched nor cached", func() {
+		cachedFeedHtml(context.Background(), cache, server.URL, "de", date.AddDate(0, 0, 1))
+	})
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// command is a subcommand of the program, which takes its own flags after its name as well as every setting.
+// Logs are written to standard error, leaving standard output to the JSON result of the command.
+type command struct {
+	name    string
+	args    string
+	summary string
+	// publishing commands need the credentials of the accounts they post for
+	publishing bool
+	// setup registers the flags of the command, and returns the function which runs it once they are parsed
+	setup func(flags *flag.FlagSet) func(ctx context.Context, config Config, args []string, stdin io.Reader, stdout io.Writer) error
+}
+
+// commands lists the subcommands in the order they are described in the usage message. Each stage of the pipeline
+// has its own, so that it can be run and debugged on its own, and their output can be piped into the next stage.
+var commands = []command{
+	{"fetch", "", "print the potd of a day, with its structured data, as JSON", false, fetchCommand},
+	{"download", "[potd.json]", "download the file of a potd printed by fetch, read from a file or standard input", false, downloadCommand},
+	{"compress", "file", "compress an image to fit the file size and dimension limits, splitting panoramas if enabled", false, compressCommand},
+	{"split", "[text]", "split text, given as arguments or on standard input, into a thread", false, splitCommand},
+	{"post", "[image...]", "post a thread printed by split, read from standard input, with the images attached", true, postCommand},
+	{"run", "", "post today's potd for every account, or keep posting every day with -daemon (the default command)", true, runCommand},
+	{"backfill", "", "post the potds of past days which are still in the feed, for accounts which missed them", true, backfillCommand},
+	{"history", "", "print the recorded posts as JSON", false, historyCommand},
+}
+
+// usage describes every subcommand.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s [command] [flags] [arguments]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nrun %s command -h for the flags of a command\n", filepath.Base(os.Args[0]))
+}
+
+// executeCommand parses the flags of the named command, loads the configuration and runs the command.
+func executeCommand(name string, arguments []string) {
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	configPath := flags.String("config", "", "configuration file; by default config.json in the user configuration directory, such as ~/.config/wikicommonspotd")
+	flagValues := registerConfigFlags(flags)
+	run := cmd.setup(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s %s [flags] %s\n\n%s\n\nflags:\n", filepath.Base(os.Args[0]), name, cmd.args, cmd.summary)
+		flags.PrintDefaults()
+	}
+	flags.Parse(arguments)
+
+	// problems with the configuration are logged with the default settings, since the configured ones are not known
+	log.SetLevel(log.DebugLevel)
+	log.SetFormatter(&log.JSONFormatter{})
+
+	// check the configuration before doing any work, since much of it is only used at the end
+	config, err := loadConfig(*configPath, os.LookupEnv, flagValues)
+	if err != nil {
+		log.WithError(err).Error("could not load configuration")
+		os.Exit(1)
+	}
+	if err := config.validate(cmd.publishing); err != nil {
+		log.WithError(err).Error("invalid configuration")
+		os.Exit(1)
+	}
+
+	// a failed command exits only once the log file is closed and the traces are exported, which are deferred below
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+	closeLog, err := setupLogging(config.Log)
+	if err != nil {
+		log.WithError(err).WithField("dir", config.Log.Dir).Panic("could not open log file")
+	}
+	defer closeLog()
+	log.WithField("command", name).Info("logger started")
+	shutdownTracing, err := setupTracing(config.Tracing)
+	if err != nil {
+		log.WithError(err).Panic("could not set up tracing")
+	}
+	defer func() {
+		// export the remaining spans even if the command failed, since those are the ones worth looking at
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.WithError(err).Warn("could not export traces")
+		}
+	}()
+	http.DefaultClient.Transport = tracingTransport(metricsTransport{base: userAgentTransport{userAgent: config.UserAgent, base: http.DefaultTransport}})
+
+	// the first SIGTERM or SIGINT cancels the command, aborting the requests in flight, and a second one exits at once
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// restore the default behaviour, so that a second signal terminates the process
+		stop()
+	}()
+	// errors returned by commands are mistakes in their use or problems outside the program, unlike panics
+	if err := run(ctx, config, flags.Args(), os.Stdin, os.Stdout); err != nil {
+		log.WithError(err).Error("command failed")
+		exitCode = 1
+		var usageErr usageError
+		if errors.As(err, &usageErr) {
+			flags.Usage()
+			exitCode = 2
+		}
+	}
+}
+
+// usageError is returned by a command whose flags or arguments are wrong, so that its usage is printed.
+type usageError struct {
+	error
+}
+
+func (e usageError) Unwrap() error {
+	return e.error
+}
+
+// printJSON writes the result of a command.
+func printJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// readInput returns the contents of the file named by the only argument, or of standard input if there is none.
+func readInput(args []string, stdin io.Reader) ([]byte, error) {
+	switch len(args) {
+	case 0:
+		return io.ReadAll(stdin)
+	case 1:
+		return os.ReadFile(args[0])
+	default:
+		return nil, usageError{fmt.Errorf("expected at most one input file, got %d", len(args))}
+	}
+}
+
+// parseDate parses a Commons day given as yyyy-mm-dd, or returns today if it is empty.
+func parseDate(date string) (time.Time, error) {
+	if date == "" {
+		return today(), nil
+	}
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return day, usageError{fmt.Errorf("invalid day %q, expected yyyy-mm-dd", date)}
+	}
+	return day, nil
+}
+
+// checkDateRange checks that the days given to -from and -to, either of which may be empty, are valid and that
+// from is not after to.
+func checkDateRange(from string, to string) error {
+	for _, date := range []string{from, to} {
+		if date == "" {
+			continue
+		}
+		if _, err := parseDate(date); err != nil {
+			return err
+		}
+	}
+	// dates in this layout sort as strings
+	if from != "" && to != "" && from > to {
+		return usageError{fmt.Errorf("-from %s is after -to %s", from, to)}
+	}
+	return nil
+}
+
+// findAccount returns the account with the given name, or the first account if the name is empty.
+func findAccount(config Config, name string) (AccountConfig, error) {
+	for _, account := range config.Accounts {
+		if name == "" || account.Name == name {
+			return account, nil
+		}
+	}
+	return AccountConfig{}, fmt.Errorf("no account named %q", name)
+}
+
+// copyFile copies the file at src to a new file at dst, and returns its size.
+func copyFile(dst string, src string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return size, err
+}
+
+func fetchCommand(flags *flag.FlagSet) func(context.Context, Config, []string, io.Reader, io.Writer) error {
+	date := flags.String("date", "", "day of the potd as yyyy-mm-dd, which must still be in the feed; today by default")
+	accountName := flags.String("account", "", "account whose language is used; the first by default")
+	return func(ctx context.Context, config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+		day, err := parseDate(*date)
+		if err != nil {
+			return err
+		}
+		account, err := findAccount(config, *accountName)
+		if err != nil {
+			return err
+		}
+		ctx, _, end := startSpan(ctx, "fetch")
+		defer end()
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(config.FetchTimeout))
+		defer cancel()
+		cache := &ImageCache{Dir: config.CacheDir, MaxBytes: config.CacheMaxBytes}
+		potd, _ := fetchPotd(ctx, config, cache, account.Language, day)
+		return printJSON(stdout, potd)
+	}
+}
+
+// DownloadResult is the output of the download command.
+type DownloadResult struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Sha1 string `json:"sha1"`
+}
+
+func downloadCommand(flags *flag.FlagSet) func(context.Context, Config, []string, io.Reader, io.Writer) error {
+	output := flags.String("o", "", "path to save the file to; by default its name on Commons in the current directory")
+	return func(ctx context.Context, config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+		input, err := readInput(args, stdin)
+		if err != nil {
+			return err
+		}
+		var potd PotdEntry
+		if err := json.Unmarshal(input, &potd); err != nil {
+			return fmt.Errorf("could not read potd: %w", err)
+		}
+		if potd.FileName == "" {
+			return errors.New("potd has no file name")
+		}
+
+		ctx, _, end := startSpan(ctx, "download")
+		defer end()
+		cache := &ImageCache{Dir: config.CacheDir, MaxBytes: config.CacheMaxBytes}
+		fetchCtx, cancel := context.WithTimeout(ctx, time.Duration(config.FetchTimeout))
+		defer cancel()
+		info := cachedImageInfo(fetchCtx, cache, potd.FileName)
+		downloaded, _, cleanup := downloadPotd(ctx, config, cache, info)
+		defer cleanup()
+
+		// vector and multi-page formats are downloaded as a raster rendition, which is named after its format
+		destination := *output
+		if destination == "" {
+			destination = path.Base(renderedUrl(info, config.RenderWidth))
+			if unescaped, err := url.PathUnescape(destination); err == nil {
+				destination = unescaped
+			}
+		}
+		size, err := copyFile(destination, downloaded)
+		if err != nil {
+			return err
+		}
+		return printJSON(stdout, DownloadResult{Path: destination, Size: size, Sha1: info.Sha1})
+	}
+}
+
+// CompressResult describes one image written by the compress command.
+type CompressResult struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+func compressCommand(flags *flag.FlagSet) func(context.Context, Config, []string, io.Reader, io.Writer) error {
+	outputDir := flags.String("o", ".", "directory to write the compressed images to, named after the input with their index")
+	return func(ctx context.Context, config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+		if len(args) != 1 {
+			return usageError{fmt.Errorf("expected one input file, got %d", len(args))}
+		}
+		input := args[0]
+		if _, err := os.Stat(input); err != nil {
+			return err
+		}
+		ctx, _, end := startSpan(ctx, "compress")
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(config.CompressTimeout))
+		defer cancel()
+		defer end()
+		opts, panorama := compressOptions(config)
+		paths := prepareImages(ctx, input, opts, panorama)
+
+		backend := newImageBackend()
+		stem := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		var results []CompressResult
+		for i, compressed := range paths {
+			destination := filepath.Join(*outputDir, fmt.Sprintf("%s-%d%s", stem, i, filepath.Ext(compressed)))
+			size, err := copyFile(destination, compressed)
+			// compressFile may return the input unchanged, which is not ours to remove
+			if compressed != input {
+				os.Remove(compressed)
+			}
+			if err != nil {
+				return err
+			}
+			buf, err := os.ReadFile(destination)
+			if err != nil {
+				return err
+			}
+			dimensions, err := backend.Size(buf)
+			if err != nil {
+				return err
+			}
+			results = append(results, CompressResult{Path: destination, Size: size, Width: dimensions.Width, Height: dimensions.Height})
+		}
+		return printJSON(stdout, results)
+	}
+}
+
+func splitCommand(flags *flag.FlagSet) func(context.Context, Config, []string, io.Reader, io.Writer) error {
+	accountName := flags.String("account", "", "account whose continuation and file link settings are used; the first by default")
+	network := flags.String("network", "twitter", "network whose length limit applies: twitter, mastodon, bluesky or telegram")
+	link := flags.String("link", "", "link to the file description page, placed as the file link setting of the account says")
+	return func(ctx context.Context, config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+		rule, ok := lengthRules[*network]
+		if !ok {
+			return usageError{fmt.Errorf("unknown network %q", *network)}
+		}
+		account, err := findAccount(config, *accountName)
+		if err != nil {
+			return err
+		}
+		text := strings.Join(args, " ")
+		if len(args) == 0 {
+			input, err := io.ReadAll(stdin)
+			if err != nil {
+				return err
+			}
+			text = string(input)
+		}
+		style, placement := account.threadStyle()
+		return printJSON(stdout, SplitThreadWithLink(strings.TrimSpace(text), style, rule, *link, placement))
+	}
+}
+
+// PostResult is the output of the post command.
+type PostResult struct {
+	Account string   `json:"account"`
+	PostIds []string `json:"postIds"`
+}
+
+func postCommand(flags *flag.FlagSet) func(context.Context, Config, []string, io.Reader, io.Writer) error {
+	accountName := flags.String("account", "", "account to post with; the first by default")
+	return func(ctx context.Context, config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+		account, err := findAccount(config, *accountName)
+		if err != nil {
+			return err
+		}
+		var tweets []string
+		if err := json.NewDecoder(stdin).Decode(&tweets); err != nil {
+			return fmt.Errorf("could not read thread: %w", err)
+		}
+		if len(tweets) == 0 {
+			return errors.New("thread is empty")
+		}
+		if err := verifyThread(tweets); err != nil {
+			return err
+		}
+		for _, image := range args {
+			if _, err := os.Stat(image); err != nil {
+				return err
+			}
+		}
+
+		ctx, _, end := startSpan(ctx, "post")
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(config.PostTimeout))
+		defer cancel()
+		defer end()
+		httpClient := getAuthorisedClient(account.Twitter)
+		postIds := postThread(ctx, httpClient, tweets, args, nil, func([]string) {})
+		return printJSON(stdout, PostResult{Account: account.Name, PostIds: postIds})
+	}
+}
+
+// verifyThread checks that every post of a thread given to the post command fits in a tweet.
+func verifyThread(tweets []string) error {
+	for i, tweet := range tweets {
+		if TwitterRule.Length(tweet) > TwitterRule.Limit() {
+			return fmt.Errorf("post %d is too long for Twitter", i+1)
+		}
+	}
+	return nil
+}
+
+func runCommand(flags *flag.FlagSet) func(context.Context, Config, []string, io.Reader, io.Writer) error {
+	return func(ctx context.Context, config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+		history := &History{Path: config.HistoryFile}
+		if config.MetricsAddress != "" {
+			serveMetrics(config.MetricsAddress, time.Duration(config.HealthMaxAge), history)
+		}
+		if config.Daemon {
+			runDaemon(ctx, config, history)
+			return nil
+		}
+		return runWithAlerts(ctx, config, history, today())
+	}
+}
+
+// BackfillResult is the outcome of posting the potd of one day in the backfill command.
+type BackfillResult struct {
+	Date  string `json:"date"`
+	Error string `json:"error,omitempty"`
+}
+
+func backfillCommand(flags *flag.FlagSet) func(context.Context, Config, []string, io.Reader, io.Writer) error {
+	from := flags.String("from", "", "first day to post as yyyy-mm-dd; the oldest in the feed by default")
+	to := flags.String("to", "", "last day to post as yyyy-mm-dd; today by default")
+	return func(ctx context.Context, config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+		if config.HistoryFile == "" {
+			return errors.New("historyFile is required to backfill, so that nothing is posted twice")
+		}
+		if err := checkDateRange(*from, *to); err != nil {
+			return err
+		}
+		last, err := parseDate(*to)
+		if err != nil {
+			return err
+		}
+		var first time.Time
+		if *from != "" {
+			if first, err = parseDate(*from); err != nil {
+				return err
+			}
+		}
+
+		// the feed only covers the last few days, so the days it lists are the only ones which can be posted
+		fetchCtx, cancel := context.WithTimeout(ctx, time.Duration(config.FetchTimeout))
+		defer cancel()
+		items := getFeedItems(fetchCtx, config.FeedUrl, config.Accounts[0].Language)
+		sort.Slice(items, func(i, j int) bool { return items[i].Date.Before(items[j].Date) })
+
+		history := &History{Path: config.HistoryFile}
+		if config.MetricsAddress != "" {
+			serveMetrics(config.MetricsAddress, time.Duration(config.HealthMaxAge), history)
+		}
+		var results []BackfillResult
+		failed := false
+		for _, item := range items {
+			if item.Date.Before(first) || item.Date.After(last) || ctx.Err() != nil {
+				continue
+			}
+			result := BackfillResult{Date: item.Date.Format(dateLayout)}
+			if err := runWithAlerts(ctx, config, history, item.Date); err != nil {
+				result.Error = err.Error()
+				failed = true
+			}
+			results = append(results, result)
+		}
+		if err := printJSON(stdout, results); err != nil {
+			return err
+		}
+		if failed {
+			return errors.New("could not post the potd of every day")
+		}
+		return nil
+	}
+}
+
+func historyCommand(flags *flag.FlagSet) func(context.Context, Config, []string, io.Reader, io.Writer) error {
+	accountName := flags.String("account", "", "only print the posts of this account")
+	from := flags.String("from", "", "only print posts of this day as yyyy-mm-dd or later")
+	to := flags.String("to", "", "only print posts of this day as yyyy-mm-dd or earlier")
+	return func(ctx context.Context, config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+		if err := checkDateRange(*from, *to); err != nil {
+			return err
+		}
+		history := &History{Path: config.HistoryFile}
+		entries, err := history.Entries()
+		if err != nil {
+			return err
+		}
+		// dates in this layout sort as strings
+		selected := []HistoryEntry{}
+		for _, entry := range entries {
+			if (*accountName == "" || entry.Account == *accountName) && entry.Date >= *from && (*to == "" || entry.Date <= *to) {
+				selected = append(selected, entry)
+			}
+		}
+		return printJSON(stdout, selected)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runTestCommand parses the arguments of a command and runs it with the given configuration and input,
+// returning its output.
+func runTestCommand(t *testing.T, setup func(*flag.FlagSet) func(context.Context, Config, []string, io.Reader, io.Writer) error, config Config, arguments []string, stdin string) []byte {
+	t.Helper()
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	run := setup(flags)
+	if err := flags.Parse(arguments); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	if err := run(context.Background(), config, flags.Args(), strings.NewReader(stdin), &stdout); err != nil {
+		t.Fatal(err)
+	}
+	return stdout.Bytes()
+}
+
+func TestSplitCommand(t *testing.T) {
+	config := defaultConfig()
+	config.Accounts = []AccountConfig{config.AccountConfig}
+	config.Accounts[0].Continuation = "counter"
+
+	var thread []string
+	output := runTestCommand(t, splitCommand, config, []string{"-network", "mastodon", "-link", "https://example.org"}, strings.Repeat("word ", 150))
+	if err := json.Unmarshal(output, &thread); err != nil {
+		t.Fatal(err)
+	}
+	if len(thread) != 2 || !strings.HasSuffix(thread[0], "https://example.org 1/2") || MastodonRule.Length(thread[0]) > 500 {
+		t.Errorf("unexpected thread %q", thread)
+	}
+}
+
+func TestHistoryCommand(t *testing.T) {
+	config := defaultConfig()
+	config.HistoryFile = filepath.Join(t.TempDir(), "history.jsonl")
+	history := &History{Path: config.HistoryFile}
+	for _, entry := range []HistoryEntry{
+		{Date: "2024-03-01", Account: "english", Complete: true},
+		{Date: "2024-03-01", Account: "german", Complete: true},
+		{Date: "2024-03-02", Account: "english", PostIds: []string{"1"}},
+	} {
+		history.Record(entry)
+	}
+
+	var entries []HistoryEntry
+	output := runTestCommand(t, historyCommand, config, []string{"-account", "english", "-from", "2024-03-02"}, "")
+	if err := json.Unmarshal(output, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Date != "2024-03-02" || !reflect.DeepEqual(entries[0].PostIds, []string{"1"}) {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+// Test that the potd of each day in the feed can be found by its date.
+func TestGetHtmlFromFeed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("language") != "de" {
+			t.Errorf("unexpected language in %s", r.URL)
+		}
+		w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Picture of the day</title>` +
+			`<item><title>1 March</title><pubDate>Fri, 01 Mar 2024 00:00:00 GMT</pubDate><description>&lt;p&gt;first&lt;/p&gt;</description></item>` +
+			`<item><title>2 March</title><pubDate>Sat, 02 Mar 2024 00:00:00 GMT</pubDate><description>&lt;p&gt;second&lt;/p&gt;</description></item>` +
+			`</channel></rss>`))
+	}))
+	defer server.Close()
+
+	if got := getHtmlFromFeed(context.Background(), server.URL+"?action=featuredfeed", "de", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)); got != "<p>first</p>" {
+		t.Errorf("unexpected html %q", got)
+	}
+	assertPanics(t, "day not in feed", func() {
+		getHtmlFromFeed(context.Background(), server.URL, "de", time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC))
+	})
+}
+
+// Test that mistakes in the flags of a command are reported as usage errors.
+func TestHistoryCommandRange(t *testing.T) {
+	config := defaultConfig()
+	config.HistoryFile = filepath.Join(t.TempDir(), "history.jsonl")
+	for _, arguments := range [][]string{
+		{"-from", "2024-03-02", "-to", "2024-03-01"},
+		{"-from", "March 2nd"},
+	} {
+		flags := flag.NewFlagSet("history", flag.ContinueOnError)
+		run := historyCommand(flags)
+		if err := flags.Parse(arguments); err != nil {
+			t.Fatal(err)
+		}
+		err := run(context.Background(), config, flags.Args(), strings.NewReader(""), io.Discard)
+		var usageErr usageError
+		if !errors.As(err, &usageErr) {
+			t.Errorf("%v: expected a usage error, got %v", arguments, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// PostData is what post templates can refer to, for example {{.Description}} or {{.Date.Format "2 January 2006"}}.
+type PostData struct {
+	PotdEntry
+	// Date is the day of the potd, in UTC.
+	Date time.Time
+	// Author and Licence are as Commons reports them for the file, and may be empty.
+	Author     string
+	Licence    string
+	LicenceUrl string
+	// Language is the language of the potd feed, such as "en".
+	Language string
+	// Place is the nearest place in the gazetteer to the coordinates of the file, such as "New York City, United States",
+	// or empty if there is none nearby. The coordinates themselves print as "40.7812°N 73.9665°W".
+	Place string
+	// Hashtags prints as hashtags separated by spaces, or nothing if there are none.
+	Hashtags Hashtags
+}
+
+// defaultPostTemplate posts the description, followed by any hashtags.
+const defaultPostTemplate = "{{.Description}} {{.Hashtags}}"
+
+// parsePostTemplate parses the template for the posts of a publisher, and checks that it renders when every field
+// is empty, as any of them may be on the day.
+func parsePostTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := composePost(tmpl, PostData{}); err != nil {
+		return nil, fmt.Errorf("template does not render with empty fields: %w", err)
+	}
+	return tmpl, nil
+}
+
+// composePost renders the text of a post, which is then split into a thread.
+func composePost(tmpl *template.Template, data PostData) (string, error) {
+	var builder strings.Builder
+	if err := tmpl.Execute(&builder, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(builder.String()), nil
+}
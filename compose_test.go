@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComposePost(t *testing.T) {
+	tmpl, err := parsePostTemplate("test", `Picture of the day, {{.Date.Format "2 January 2006"}}: {{.Description}}{{if .Author}} by {{.Author}}{{end}}{{if .Licence}} ({{.Licence}}){{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := PostData{
+		PotdEntry: PotdEntry{Description: "A woodpecker."},
+		Date:      time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC),
+		Author:    "Example",
+		Licence:   "CC BY-SA 4.0",
+	}
+	expected := "Picture of the day, 16 October 2026: A woodpecker. by Example (CC BY-SA 4.0)"
+	if got, err := composePost(tmpl, data); err != nil || got != expected {
+		t.Errorf("expected %q, got %q (err %v)", expected, got, err)
+	}
+
+	tmpl, err = parsePostTemplate("default", defaultPostTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := composePost(tmpl, data); err != nil || got != "A woodpecker." {
+		t.Errorf("expected the description alone, got %q (err %v)", got, err)
+	}
+}
+
+// Test that templates which only work when fields are set are rejected up front.
+func TestParsePostTemplateRejects(t *testing.T) {
+	for _, text := range []string{
+		"{{.Description",
+		"{{.Tags}}",
+		"{{slice .Author 0 3}}",
+	} {
+		if _, err := parsePostTemplate("test", text); err == nil {
+			t.Errorf("expected template %q to be rejected", text)
+		}
+	}
+}
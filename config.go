@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config holds every setting, layered from lowest to highest precedence: the defaults, the configuration file,
+// environment variables, files named by environment variables ending in _FILE, and command line flags.
+//
+// Each field is named in the configuration file by its JSON key, nested as in the struct. The corresponding
+// environment variable is the path of keys in upper snake case after envPrefix, such as
+// WIKICOMMONSPOTD_TWITTER_API_KEY, and the flag is the path in kebab case, such as -jpeg-quality. Secrets have no flag,
+// since command lines are visible to other users. Lists are separated by commas in environment variables and flags.
+type Config struct {
+	FeedUrl   string `json:"feedUrl" usage:"URL of the potd feed, to which the language is added"`
+	UserAgent string `json:"userAgent" usage:"User-Agent header sent with every request to Wikimedia, as its policy requires"`
+
+	CacheDir      string `json:"cacheDir" usage:"directory in which downloaded and compressed images are cached"`
+	CacheMaxBytes int64  `json:"cacheMaxBytes" usage:"total size of the image cache, above which the least recently used images are evicted; 0 disables the cache"`
+
+	DownloadTimeout     Duration `json:"downloadTimeout" usage:"time allowed for downloading the potd image, including resumed attempts"`
+	DownloadMaxBytes    int64    `json:"downloadMaxBytes" usage:"largest potd image which will be downloaded"`
+	DownloadMaxAttempts int      `json:"downloadMaxAttempts" usage:"requests made before giving up on an interrupted download"`
+
+	FetchTimeout    Duration `json:"fetchTimeout" usage:"time allowed for fetching the potd from the feed, with the information, structured data and categories of its file"`
+	CompressTimeout Duration `json:"compressTimeout" usage:"time allowed for compressing the potd image, which is checked before each re-encoding"`
+	PostTimeout     Duration `json:"postTimeout" usage:"time allowed for each account to upload the images and post its thread; a thread which is cut short is continued by the next run"`
+
+	JpegQuality    int      `json:"jpegQuality" usage:"JPEG quality used for photographs"`
+	FileSizeLimit  int      `json:"fileSizeLimit" usage:"size in bytes which uploaded images must be below"`
+	MaxDimension   int      `json:"maxDimension" usage:"largest width or height of uploaded images"`
+	RenderWidth    int      `json:"renderWidth" usage:"width at which SVG files are rasterised, and PDF, DjVu and TIFF files rendered unless they are narrower, by the Commons thumbnailer"`
+	Background     Colour   `json:"background" usage:"colour onto which transparent images are flattened, as #rrggbb"`
+	ExifAllowlist  []string `json:"exifAllowlist" usage:"EXIF fields kept in uploaded images; all others are removed"`
+	SplitPanoramas bool     `json:"splitPanoramas" usage:"attach full resolution sections of images with an extreme aspect ratio alongside the overview"`
+
+	Gazetteer string `json:"gazetteer" usage:"gazetteer in which the coordinates of the file are looked up, as tab-separated name, country, latitude and longitude or a GeoNames cities file; the bundled one is used by default"`
+
+	HistoryFile   string   `json:"historyFile" usage:"file in which the posts of each account are recorded, so that a potd is never posted twice and an interrupted thread is resumed; empty disables it"`
+	Daemon        bool     `json:"daemon" usage:"keep running and post every day at -run-at, instead of posting once and exiting"`
+	RunAt         string   `json:"runAt" usage:"time of day in UTC, as hh:mm, at which the daemon posts; Commons changes the potd at midnight UTC"`
+	RetryInterval Duration `json:"retryInterval" usage:"time the daemon waits before retrying a failed run"`
+	CatchUpDays   int      `json:"catchUpDays" usage:"number of past days the daemon posts on starting and on every wake, if they were missed and are still in the feed; 0 disables it"`
+
+	MetricsAddress string   `json:"metricsAddress" usage:"address, such as :9090, on which Prometheus metrics are served at /metrics and a health check at /healthz while posting; empty disables it"`
+	HealthMaxAge   Duration `json:"healthMaxAge" usage:"time since the last successful post after which /healthz reports the program unhealthy"`
+
+	Log     LogConfig     `json:"log"`
+	Tracing TracingConfig `json:"tracing"`
+	Alerts  AlertsConfig  `json:"alerts"`
+
+	// AccountConfig holds the settings of the only account if no accounts are listed, and otherwise the defaults for
+	// every account, which each account can override in its entry in the configuration file.
+	AccountConfig
+	// RawAccounts are the entries of accounts in the configuration file, which are resolved into Accounts.
+	RawAccounts []json.RawMessage `json:"accounts" config:"file"`
+	Accounts    []AccountConfig   `json:"-"`
+}
+
+// AccountConfig holds the settings of one account, which posts in its own language to its own publishers.
+type AccountConfig struct {
+	Name     string `json:"name" usage:"name of the account, used in logs and in the environment variables of its settings"`
+	Language string `json:"language" usage:"language of the potd feed, and so of the description"`
+
+	Continuation       string `json:"continuation" usage:"how posts in a thread are marked as continuing one another: ellipsis, counter or thread"`
+	ContinuationPrefix string `json:"continuationPrefix" usage:"custom text added to the start of every post in a thread, where {n} and {total} are replaced with the post's position and the thread length"`
+	ContinuationSuffix string `json:"continuationSuffix" usage:"custom text added to the end of every post in a thread, as for -continuation-prefix"`
+	FileLink           string `json:"fileLink" usage:"where the link to the file description page on Commons is posted: first (in the first post), last (as a post of its own at the end of the thread) or none"`
+
+	Hashtags         int      `json:"hashtags" usage:"most hashtags made from the depicted items and categories of the file, added at the end of the post by the default template; 0 disables them"`
+	HashtagBlocklist []string `json:"hashtagBlocklist" usage:"hashtags which are never used"`
+	PlaceMaxDistance float64  `json:"placeMaxDistance" usage:"distance in kilometres from the coordinates of the file within which a place is named in {{.Place}}"`
+
+	Filter  FilterConfig  `json:"filter"`
+	Twitter TwitterConfig `json:"twitter"`
+}
+
+// FilterConfig decides which potds an account posts. An empty filter lets every potd through.
+type FilterConfig struct {
+	Orientation       string   `json:"orientation" usage:"only post pictures in this orientation: landscape, portrait, or empty for any"`
+	Categories        []string `json:"categories" usage:"only post files in a Commons category containing one of these, ignoring case"`
+	ExcludeCategories []string `json:"excludeCategories" usage:"never post files in a Commons category containing one of these, ignoring case"`
+	Depicts           []string `json:"depicts" usage:"only post files depicting one of these Wikidata items, given by id such as Q60"`
+}
+
+// TwitterConfig holds the settings of the Twitter publisher.
+type TwitterConfig struct {
+	Enabled           bool   `json:"enabled" usage:"post to Twitter"`
+	ApiKey            string `json:"apiKey" secret:"true"`
+	ApiKeySecret      string `json:"apiKeySecret" secret:"true"`
+	AccessToken       string `json:"accessToken" secret:"true"`
+	AccessTokenSecret string `json:"accessTokenSecret" secret:"true"`
+	Template          string `json:"template" usage:"text/template for the text of the tweets, before it is split into a thread; see PostData for the fields available"`
+}
+
+// LogConfig holds the settings of the logs.
+type LogConfig struct {
+	Level    string `json:"level" usage:"least severe level of log entries which are written: trace, debug, info, warning, error or panic"`
+	Format   string `json:"format" usage:"format of log entries: json, or text for reading in a terminal"`
+	Dir      string `json:"dir" usage:"directory in which logs are written, instead of standard error, to files rotated by size"`
+	MaxBytes int64  `json:"maxBytes" usage:"size beyond which a log file is rotated"`
+	MaxFiles int    `json:"maxFiles" usage:"rotated log files kept in the log directory, besides the current one"`
+}
+
+// TracingConfig holds where the spans of each run are exported. Nothing is traced unless one of them is set.
+type TracingConfig struct {
+	Endpoint string `json:"endpoint" usage:"URL of an OTLP/HTTP collector to which traces are exported, such as http://localhost:4318"`
+	File     string `json:"file" usage:"file to which the spans of traces are appended as JSON, for debugging without a collector"`
+}
+
+// AlertsConfig holds the channels to which failed runs, and the recovery which follows them, are reported.
+// No alerts are sent unless at least one channel is configured.
+type AlertsConfig struct {
+	StateFile    string   `json:"stateFile" usage:"file in which the failure last alerted on is kept, so that it is not repeated and a recovery notice follows it"`
+	WebhookUrl   string   `json:"webhookUrl" usage:"URL to which alerts are posted as JSON"`
+	NtfyUrl      string   `json:"ntfyUrl" usage:"URL of the ntfy topic to which alerts are pushed, such as https://ntfy.sh/mytopic"`
+	NtfyToken    string   `json:"ntfyToken" secret:"true"`
+	SmtpAddress  string   `json:"smtpAddress" usage:"host:port of the SMTP server through which alerts are emailed"`
+	SmtpUsername string   `json:"smtpUsername" usage:"username with which to authenticate to the SMTP server; empty sends without authentication"`
+	SmtpPassword string   `json:"smtpPassword" secret:"true"`
+	SmtpFrom     string   `json:"smtpFrom" usage:"sender address of alert emails"`
+	SmtpTo       []string `json:"smtpTo" usage:"recipient addresses of alert emails"`
+	Timeout      Duration `json:"timeout" usage:"time allowed for sending an alert to each channel"`
+}
+
+// envPrefix starts the name of every environment variable which is read as configuration.
+const envPrefix = "WIKICOMMONSPOTD_"
+
+// defaultConfig returns the settings used unless they are configured otherwise.
+func defaultConfig() Config {
+	return Config{
+		FeedUrl:             "https://commons.wikimedia.org/w/api.php?action=featuredfeed&feed=potd",
+		UserAgent:           "wikicommonspotd (https://github.com/CicadaCinema/wikicommonspotd)",
+		CacheDir:            defaultCacheDir(),
+		CacheMaxBytes:       2000000000,
+		DownloadTimeout:     Duration(10 * time.Minute),
+		DownloadMaxBytes:    500000000,
+		DownloadMaxAttempts: 3,
+		FetchTimeout:        Duration(2 * time.Minute),
+		CompressTimeout:     Duration(5 * time.Minute),
+		PostTimeout:         Duration(5 * time.Minute),
+		JpegQuality:         90,
+		FileSizeLimit:       5000000,
+		MaxDimension:        4096,
+		RenderWidth:         2048,
+		Background:          Colour{R: 255, G: 255, B: 255, A: 255},
+		ExifAllowlist:       []string{"Artist", "Copyright"},
+		HistoryFile:         defaultStateFile("history.jsonl"),
+		RunAt:               "00:30",
+		RetryInterval:       Duration(30 * time.Minute),
+		CatchUpDays:         7,
+		HealthMaxAge:        Duration(26 * time.Hour),
+		Log:                 LogConfig{Level: "debug", Format: "json", MaxBytes: 10000000, MaxFiles: 30},
+		Alerts:              AlertsConfig{StateFile: defaultStateFile("alerts.json"), Timeout: Duration(30 * time.Second)},
+		AccountConfig: AccountConfig{
+			Name:             "default",
+			Language:         "en",
+			Continuation:     "ellipsis",
+			FileLink:         "first",
+			HashtagBlocklist: defaultHashtagBlocklist,
+			PlaceMaxDistance: 50,
+			Twitter: TwitterConfig{
+				Enabled:  true,
+				Template: defaultPostTemplate,
+			},
+		},
+	}
+}
+
+// Duration is a time.Duration written as a string such as "10m" in configuration.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	*d = Duration(parsed)
+	return err
+}
+
+// Colour is an opaque colour written as "#rrggbb" in configuration.
+type Colour color.RGBA
+
+func (c Colour) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)), nil
+}
+
+func (c *Colour) UnmarshalText(text []byte) error {
+	var r, g, b uint8
+	if _, err := fmt.Sscanf(string(text), "#%02x%02x%02x", &r, &g, &b); err != nil || len(text) != 7 {
+		return fmt.Errorf("expected a colour as #rrggbb, got %q", text)
+	}
+	*c = Colour{R: r, G: g, B: b, A: 255}
+	return nil
+}
+
+// configField is a setting found by walking the fields of Config.
+type configField struct {
+	// key is the path of JSON keys to the field, such as "twitter.apiKey".
+	key    string
+	flag   string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// configFields lists the settings in a configuration struct, recursing into nested structs.
+func configFields(v reflect.Value, path []string) []configField {
+	var fields []configField
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "-" || structField.Tag.Get("config") == "file" {
+			continue
+		}
+		value := v.Field(i)
+		if structField.Anonymous {
+			fields = append(fields, configFields(value, path)...)
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), name)
+
+		_, textual := value.Addr().Interface().(encoding.TextUnmarshaler)
+		if value.Kind() == reflect.Struct && !textual {
+			fields = append(fields, configFields(value, fieldPath)...)
+			continue
+		}
+
+		var words []string
+		for _, part := range fieldPath {
+			words = append(words, splitCamelCase(part)...)
+		}
+		fields = append(fields, configField{
+			key:    strings.Join(fieldPath, "."),
+			flag:   strings.ToLower(strings.Join(words, "-")),
+			env:    envPrefix + strings.Map(envRune, strings.ToUpper(strings.Join(words, "_"))),
+			usage:  structField.Tag.Get("usage"),
+			secret: structField.Tag.Get("secret") == "true",
+			value:  value,
+		})
+	}
+	return fields
+}
+
+// envRune replaces characters which cannot be used in the names of environment variables, such as those in the
+// names of accounts, with underscores.
+func envRune(r rune) rune {
+	if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+		return r
+	}
+	return '_'
+}
+
+// splitCamelCase divides a name such as "apiKeySecret" into its words.
+func splitCamelCase(name string) []string {
+	var words []string
+	start := 0
+	runes := []rune(name)
+	for i := 1; i < len(runes); i++ {
+		if unicode.IsUpper(runes[i]) && !unicode.IsUpper(runes[i-1]) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+// setFromString sets a setting from its text in an environment variable, secret file or flag.
+func (field configField) setFromString(text string) error {
+	if unmarshaler, ok := field.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(text))
+	}
+	switch field.value.Kind() {
+	case reflect.String:
+		field.value.SetString(text)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.value.SetInt(parsed)
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		field.value.SetFloat(parsed)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		field.value.SetBool(parsed)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.value.Type())
+	}
+	return nil
+}
+
+// String formats a setting as it would be written in an environment variable or flag.
+func (field configField) String() string {
+	if marshaler, ok := field.value.Interface().(encoding.TextMarshaler); ok {
+		text, _ := marshaler.MarshalText()
+		return string(text)
+	}
+	if field.value.Kind() == reflect.Slice {
+		return strings.Join(field.value.Interface().([]string), ",")
+	}
+	return fmt.Sprint(field.value.Interface())
+}
+
+// configFlag records the value of a flag for a setting, to be applied once the layers beneath it are loaded.
+type configFlag struct {
+	isBool bool
+	set    func(string)
+}
+
+func (f configFlag) String() string     { return "" }
+func (f configFlag) Set(s string) error { f.set(s); return nil }
+func (f configFlag) IsBoolFlag() bool   { return f.isBool }
+
+// registerConfigFlags adds a flag for every setting which is not a secret, and returns the values of those given on
+// the command line, keyed by flag name, once the flag set has been parsed.
+func registerConfigFlags(flags *flag.FlagSet) map[string]string {
+	values := map[string]string{}
+	defaults := defaultConfig()
+	for _, field := range configFields(reflect.ValueOf(&defaults).Elem(), nil) {
+		if field.secret {
+			continue
+		}
+		name := field.flag
+		flags.Var(configFlag{
+			isBool: field.value.Kind() == reflect.Bool,
+			set:    func(s string) { values[name] = s },
+		}, name, fmt.Sprintf("%s (default %q, or %s)", field.usage, field.String(), field.env))
+	}
+	return values
+}
+
+// legacyKeys maps the keys of the original conf.json, which only held Twitter credentials, to their place now.
+var legacyKeys = map[string]string{
+	"ApiKey":            "apiKey",
+	"ApiKeySecret":      "apiKeySecret",
+	"AccessToken":       "accessToken",
+	"AccessTokenSecret": "accessTokenSecret",
+}
+
+// configFilePath returns the configuration file used when none is given: config.json in the user configuration
+// directory, such as ~/.config/wikicommonspotd, or else a conf.json left in the working directory by older versions.
+// It returns an empty string if there is neither.
+func configFilePath() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		path := filepath.Join(dir, "wikicommonspotd", "config.json")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	if _, err := os.Stat("conf.json"); err == nil {
+		log.Warn("using conf.json from the working directory, which is deprecated; move it to the user configuration directory or pass -config")
+		return "conf.json"
+	}
+	return ""
+}
+
+// decodeConfigFile reads a configuration file over the settings already in config, rejecting unknown keys.
+func decodeConfigFile(buf []byte, config *Config) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return err
+	}
+
+	// move credentials from the original format into the twitter section
+	twitter := map[string]json.RawMessage{}
+	for legacy, key := range legacyKeys {
+		if value, ok := raw[legacy]; ok {
+			twitter[key] = value
+			delete(raw, legacy)
+		}
+	}
+	if len(twitter) > 0 {
+		if section, ok := raw["twitter"]; ok {
+			if err := json.Unmarshal(section, &twitter); err != nil {
+				return err
+			}
+		}
+		section, err := json.Marshal(twitter)
+		if err != nil {
+			return err
+		}
+		raw["twitter"] = section
+		if buf, err = json.Marshal(raw); err != nil {
+			return err
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+}
+
+// loadConfig layers the defaults, the configuration file at path (or the default one if path is empty), the
+// environment as given by lookupEnv, and the values of flags returned by registerConfigFlags.
+func loadConfig(path string, lookupEnv func(string) (string, bool), flagValues map[string]string) (Config, error) {
+	config := defaultConfig()
+
+	if path == "" {
+		path = configFilePath()
+	}
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return config, err
+		}
+		if err := decodeConfigFile(buf, &config); err != nil {
+			return config, fmt.Errorf("%s: %w", path, err)
+		}
+		log.WithField("path", path).Info("read configuration file")
+	}
+
+	fields := configFields(reflect.ValueOf(&config).Elem(), nil)
+	if err := applyEnv(fields, lookupEnv); err != nil {
+		return config, err
+	}
+	if err := applyFlags(fields, flagValues); err != nil {
+		return config, err
+	}
+
+	err := config.resolveAccounts(lookupEnv, flagValues)
+	return config, err
+}
+
+// applyFlags sets each setting whose flag was given, from the values returned by registerConfigFlags.
+func applyFlags(fields []configField, flagValues map[string]string) error {
+	for _, field := range fields {
+		if value, ok := flagValues[field.flag]; ok {
+			if err := field.setFromString(value); err != nil {
+				return fmt.Errorf("-%s: %w", field.flag, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyEnv sets each setting which has an environment variable, or a file named by its _FILE variable.
+func applyEnv(fields []configField, lookupEnv func(string) (string, bool)) error {
+	for _, field := range fields {
+		value, ok := lookupEnv(field.env)
+		secretPath, fromFile := lookupEnv(field.env + "_FILE")
+		if ok && fromFile {
+			return fmt.Errorf("both %s and %s_FILE are set", field.env, field.env)
+		}
+		if fromFile {
+			secret, err := os.ReadFile(secretPath)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", field.env, err)
+			}
+			value, ok = strings.TrimRight(string(secret), "\r\n"), true
+		}
+		if !ok {
+			continue
+		}
+		if err := field.setFromString(value); err != nil {
+			return fmt.Errorf("%s: %w", field.env, err)
+		}
+	}
+	return nil
+}
+
+// resolveAccounts fills in Accounts. Each account starts from the settings of the default account in the
+// configuration file, overridden by its entry there. The environment variables of the default account come next,
+// then those named after the account, such as WIKICOMMONSPOTD_ACCOUNT_GERMAN_TWITTER_API_KEY for the account named
+// "german", and last the flags, which apply to every account. If no accounts are listed, the default account is the
+// only one.
+func (config *Config) resolveAccounts(lookupEnv func(string) (string, bool), flagValues map[string]string) error {
+	if len(config.RawAccounts) == 0 {
+		config.Accounts = []AccountConfig{config.AccountConfig}
+		return nil
+	}
+
+	// encoding the defaults and decoding them for each account copies them without sharing any lists
+	defaults, err := json.Marshal(config.AccountConfig)
+	if err != nil {
+		return err
+	}
+	config.Accounts = nil
+	for i, raw := range config.RawAccounts {
+		var account AccountConfig
+		if err := json.Unmarshal(defaults, &account); err != nil {
+			return err
+		}
+		account.Name = ""
+
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&account); err != nil {
+			return fmt.Errorf("accounts[%d]: %w", i, err)
+		}
+		if account.Name == "" {
+			return fmt.Errorf("accounts[%d]: name is required", i)
+		}
+		// the entry would otherwise override the environment and flags of the default account, which are layered
+		// above the file; the name of the default account is not one of its settings which accounts inherit
+		inherited := slices.DeleteFunc(configFields(reflect.ValueOf(&account).Elem(), nil), func(field configField) bool {
+			return field.key == "name"
+		})
+		if err := applyEnv(inherited, lookupEnv); err != nil {
+			return err
+		}
+		if err := applyEnv(configFields(reflect.ValueOf(&account).Elem(), []string{"account", account.Name}), lookupEnv); err != nil {
+			return err
+		}
+		if err := applyFlags(inherited, flagValues); err != nil {
+			return err
+		}
+		config.Accounts = append(config.Accounts, account)
+	}
+	return nil
+}
+
+// validate checks that the settings make sense together, and when publishing, that every enabled publisher has
+// what it needs, reporting every problem at once.
+func (config Config) validate(publishing bool) error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	feedUrl, err := url.Parse(config.FeedUrl)
+	check(err == nil && feedUrl.IsAbs(), "feedUrl must be an absolute URL, got %q", config.FeedUrl)
+	check(config.UserAgent != "", "userAgent is required")
+	check(config.DownloadTimeout > 0, "downloadTimeout must be positive")
+	check(config.DownloadMaxBytes > 0, "downloadMaxBytes must be positive")
+	check(config.DownloadMaxAttempts > 0, "downloadMaxAttempts must be positive")
+	check(config.FetchTimeout > 0, "fetchTimeout must be positive")
+	check(config.CompressTimeout > 0, "compressTimeout must be positive")
+	check(config.PostTimeout > 0, "postTimeout must be positive")
+	check(config.JpegQuality >= 1 && config.JpegQuality <= 100, "jpegQuality must be between 1 and 100, got %d", config.JpegQuality)
+	check(config.FileSizeLimit > 0, "fileSizeLimit must be positive")
+	check(config.MaxDimension > 0, "maxDimension must be positive")
+	check(config.RenderWidth > 0, "renderWidth must be positive")
+	_, err = parseClock(config.RunAt)
+	check(err == nil, "runAt must be a time of day as hh:mm, got %q", config.RunAt)
+	check(config.RetryInterval > 0, "retryInterval must be positive")
+	check(config.CatchUpDays >= 0, "catchUpDays must not be negative")
+	check(config.HealthMaxAge > 0, "healthMaxAge must be positive")
+	check(!config.Daemon || config.HistoryFile != "", "historyFile is required in daemon mode, so that runs are not repeated")
+	check(len(config.Accounts) > 0, "no accounts are configured")
+
+	_, err = log.ParseLevel(config.Log.Level)
+	check(err == nil, "log.level must be one of trace, debug, info, warning, error or panic, got %q", config.Log.Level)
+	check(config.Log.Format == "json" || config.Log.Format == "text", "log.format must be json or text, got %q", config.Log.Format)
+	check(config.Log.MaxBytes > 0, "log.maxBytes must be positive")
+	check(config.Log.MaxFiles >= 0, "log.maxFiles must not be negative")
+
+	tracingUrl, err := url.Parse(config.Tracing.Endpoint)
+	check(config.Tracing.Endpoint == "" || err == nil && tracingUrl.IsAbs(), "tracing.endpoint must be an absolute URL, got %q", config.Tracing.Endpoint)
+
+	alerts := config.Alerts
+	webhookUrl, err := url.Parse(alerts.WebhookUrl)
+	check(alerts.WebhookUrl == "" || err == nil && webhookUrl.IsAbs(), "alerts.webhookUrl must be an absolute URL, got %q", alerts.WebhookUrl)
+	ntfyUrl, err := url.Parse(alerts.NtfyUrl)
+	check(alerts.NtfyUrl == "" || err == nil && ntfyUrl.IsAbs(), "alerts.ntfyUrl must be an absolute URL, got %q", alerts.NtfyUrl)
+	check(alerts.Timeout > 0, "alerts.timeout must be positive")
+	if alerts.SmtpAddress != "" {
+		_, _, err := net.SplitHostPort(alerts.SmtpAddress)
+		check(err == nil, "alerts.smtpAddress must be host:port, got %q", alerts.SmtpAddress)
+		check(alerts.SmtpFrom != "", "alerts.smtpFrom is required to send alerts by email")
+		check(len(alerts.SmtpTo) > 0, "alerts.smtpTo is required to send alerts by email")
+	}
+
+	names := map[string]bool{}
+	for _, account := range config.Accounts {
+		check(!names[account.Name], "account name %q is used more than once", account.Name)
+		names[account.Name] = true
+		for _, err := range account.validate(publishing) {
+			errs = append(errs, fmt.Errorf("account %q: %w", account.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate checks the settings of an account, returning every problem, including those of its publishers when
+// publishing.
+func (account AccountConfig) validate(publishing bool) []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(account.Language != "", "language is required")
+	_, ok := continuationStyles[account.Continuation]
+	check(ok, "unknown continuation style %q", account.Continuation)
+	_, ok = linkPlacements[account.FileLink]
+	check(ok, "unknown file link placement %q", account.FileLink)
+	check(account.Hashtags >= 0, "hashtags must not be negative")
+
+	check(account.Filter.Orientation == "" || account.Filter.Orientation == "landscape" || account.Filter.Orientation == "portrait",
+		"filter.orientation must be landscape, portrait or empty, got %q", account.Filter.Orientation)
+	for _, id := range account.Filter.Depicts {
+		check(strings.HasPrefix(id, "Q"), "filter.depicts must list Wikidata ids such as Q60, got %q", id)
+	}
+
+	_, err := parsePostTemplate("twitter", account.Twitter.Template)
+	check(err == nil, "twitter.template: %v", err)
+	if !publishing {
+		return errs
+	}
+
+	check(account.Twitter.Enabled, "no publisher is enabled")
+	if account.Twitter.Enabled {
+		check(account.Twitter.ApiKey != "", "twitter.apiKey is required when posting to Twitter")
+		check(account.Twitter.ApiKeySecret != "", "twitter.apiKeySecret is required when posting to Twitter")
+		check(account.Twitter.AccessToken != "", "twitter.accessToken is required when posting to Twitter")
+		check(account.Twitter.AccessTokenSecret != "", "twitter.accessTokenSecret is required when posting to Twitter")
+	}
+	return errs
+}
+
+// userAgentTransport adds a User-Agent header to every request.
+type userAgentTransport struct {
+	userAgent string
+	base      http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.base.RoundTrip(req)
+}
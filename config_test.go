@@ -0,0 +1,254 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envMap looks up environment variables in a map instead of the environment of the test.
+func envMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	os.WriteFile(path, []byte(`{"language": "de", "jpegQuality": 80, "downloadTimeout": "5m", "twitter": {"apiKey": "file key", "apiKeySecret": "file secret"}}`), 0644)
+	secretPath := filepath.Join(dir, "secret")
+	os.WriteFile(secretPath, []byte("secret from file\n"), 0600)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagValues := registerConfigFlags(flags)
+	if err := flags.Parse([]string{"-jpeg-quality", "70", "-split-panoramas", "-hashtag-blocklist", "Birds, Trees"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadConfig(path, envMap(map[string]string{
+		"WIKICOMMONSPOTD_JPEG_QUALITY":              "75",
+		"WIKICOMMONSPOTD_BACKGROUND":                "#000000",
+		"WIKICOMMONSPOTD_TWITTER_API_KEY":           "env key",
+		"WIKICOMMONSPOTD_TWITTER_ACCESS_TOKEN_FILE": secretPath,
+	}), flagValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := defaultConfig()
+	expected.Language = "de"
+	expected.DownloadTimeout = Duration(5 * time.Minute)
+	expected.JpegQuality = 70
+	expected.SplitPanoramas = true
+	expected.HashtagBlocklist = []string{"Birds", "Trees"}
+	expected.Background = Colour{A: 255}
+	expected.Twitter.ApiKey = "env key"
+	expected.Twitter.ApiKeySecret = "file secret"
+	expected.Twitter.AccessToken = "secret from file"
+	expected.Accounts = []AccountConfig{expected.AccountConfig}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("expected %+v, got %+v", expected, config)
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	dir := t.TempDir()
+	unknown := filepath.Join(dir, "unknown.json")
+	os.WriteFile(unknown, []byte(`{"jpegQuallity": 80}`), 0644)
+	if _, err := loadConfig(unknown, envMap(nil), nil); err == nil {
+		t.Error("expected misspelt key to be rejected")
+	}
+
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{}`), 0644)
+	for name, value := range map[string]string{"WIKICOMMONSPOTD_JPEG_QUALITY": "high", "WIKICOMMONSPOTD_BACKGROUND": "white"} {
+		if _, err := loadConfig(valid, envMap(map[string]string{name: value}), nil); err == nil {
+			t.Errorf("expected %s=%s to be rejected", name, value)
+		}
+	}
+	env := envMap(map[string]string{"WIKICOMMONSPOTD_TWITTER_API_KEY": "a", "WIKICOMMONSPOTD_TWITTER_API_KEY_FILE": "b"})
+	if _, err := loadConfig(valid, env, nil); err == nil {
+		t.Error("expected a setting given both directly and in a file to be rejected")
+	}
+}
+
+// Test that each account inherits the top-level account settings, and can override them in its entry in the
+// configuration file and in environment variables named after it.
+func TestLoadConfigAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{
+		"hashtags": 3,
+		"twitter": {"apiKey": "shared key", "apiKeySecret": "shared secret"},
+		"accounts": [
+			{"name": "english"},
+			{"name": "german", "language": "de", "filter": {"orientation": "landscape"}, "twitter": {"template": "{{.Description}}"}}
+		]
+	}`), 0644)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagValues := registerConfigFlags(flags)
+	if err := flags.Parse([]string{"-hashtag-blocklist", "Birds"}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path, envMap(map[string]string{
+		"WIKICOMMONSPOTD_TWITTER_ACCESS_TOKEN":                 "shared token",
+		"WIKICOMMONSPOTD_ACCOUNT_GERMAN_TWITTER_API_KEY":       "german key",
+		"WIKICOMMONSPOTD_ACCOUNT_ENGLISH_TWITTER_ACCESS_TOKEN": "english token",
+	}), flagValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Accounts) != 2 {
+		t.Fatalf("expected two accounts, got %+v", config.Accounts)
+	}
+
+	english, german := config.Accounts[0], config.Accounts[1]
+	if english.Name != "english" || english.Language != "en" || english.Hashtags != 3 || english.Twitter.Template != defaultPostTemplate {
+		t.Errorf("expected english account to inherit the defaults, got %+v", english)
+	}
+	if german.Name != "german" || german.Language != "de" || german.Filter.Orientation != "landscape" || german.Twitter.Template != "{{.Description}}" {
+		t.Errorf("expected german account to override the defaults, got %+v", german)
+	}
+	if english.Twitter.ApiKey != "shared key" || german.Twitter.ApiKey != "german key" || german.Twitter.ApiKeySecret != "shared secret" {
+		t.Errorf("unexpected api keys %+v and %+v", english.Twitter, german.Twitter)
+	}
+	if english.Twitter.AccessToken != "english token" || german.Twitter.AccessToken != "shared token" {
+		t.Errorf("unexpected access tokens %+v and %+v", english.Twitter, german.Twitter)
+	}
+	if !reflect.DeepEqual(german.HashtagBlocklist, []string{"Birds"}) {
+		t.Errorf("expected flags to set the defaults of every account, got %v", german.HashtagBlocklist)
+	}
+
+	// the accounts must not share lists
+	english.HashtagBlocklist[0] = "Trees"
+	if german.HashtagBlocklist[0] != "Birds" {
+		t.Error("expected accounts to have their own copy of each list")
+	}
+}
+
+// Test that the environment and flags of the default account are not overridden by the entries of accounts in the
+// configuration file, which is the layer beneath them.
+func TestLoadConfigAccountPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{
+		"accounts": [
+			{"name": "english", "hashtags": 1},
+			{"name": "german", "language": "de", "hashtags": 1, "continuation": "counter"}
+		]
+	}`), 0644)
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flagValues := registerConfigFlags(flags)
+	if err := flags.Parse([]string{"-name", "renamed", "-continuation", "thread"}); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(path, envMap(map[string]string{
+		"WIKICOMMONSPOTD_LANGUAGE":                "fr",
+		"WIKICOMMONSPOTD_HASHTAGS":                "5",
+		"WIKICOMMONSPOTD_ACCOUNT_GERMAN_HASHTAGS": "2",
+	}), flagValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	english, german := config.Accounts[0], config.Accounts[1]
+	if english.Name != "english" || german.Name != "german" {
+		t.Errorf("expected the accounts to keep their names, got %q and %q", english.Name, german.Name)
+	}
+	if english.Language != "fr" || german.Language != "fr" {
+		t.Errorf("expected the environment to set the language of every account, got %q and %q", english.Language, german.Language)
+	}
+	if english.Hashtags != 5 || german.Hashtags != 2 {
+		t.Errorf("expected the environment of an account to take precedence over that of the default account, got %d and %d", english.Hashtags, german.Hashtags)
+	}
+	if english.Continuation != "thread" || german.Continuation != "thread" {
+		t.Errorf("expected the flags to set the continuation of every account, got %q and %q", english.Continuation, german.Continuation)
+	}
+}
+
+func TestLoadConfigRejectsAccounts(t *testing.T) {
+	for name, accounts := range map[string]string{
+		"unnamed account":      `[{"language": "de"}]`,
+		"misspelt account key": `[{"name": "german", "langauge": "de"}]`,
+	} {
+		path := filepath.Join(t.TempDir(), "config.json")
+		os.WriteFile(path, []byte(`{"accounts": `+accounts+`}`), 0644)
+		if _, err := loadConfig(path, envMap(nil), nil); err == nil {
+			t.Errorf("expected %s to be rejected", name)
+		}
+	}
+
+	config := defaultConfig()
+	config.Accounts = []AccountConfig{config.AccountConfig, config.AccountConfig}
+	config.Accounts[1].Filter.Orientation = "square"
+	err := config.validate(true)
+	for _, problem := range []string{`account name "default" is used more than once`, "filter.orientation"} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported, got %v", problem, err)
+		}
+	}
+}
+
+// Test that the original conf.json, with only Twitter credentials, is still understood.
+func TestLoadLegacyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf.json")
+	os.WriteFile(path, []byte(`{"ApiKey": "a", "ApiKeySecret": "b", "AccessToken": "c", "AccessTokenSecret": "d"}`), 0644)
+	config, err := loadConfig(path, envMap(nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Twitter.ApiKey != "a" || config.Twitter.AccessTokenSecret != "d" {
+		t.Errorf("expected legacy credentials to be read, got %+v", config.Twitter)
+	}
+	if err := config.validate(true); err != nil {
+		t.Errorf("expected legacy configuration to be valid, got %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := defaultConfig()
+	config.JpegQuality = 0
+	config.Continuation = "dots"
+	config.Twitter.Template = "{{.Description"
+	config.resolveAccounts(envMap(nil), nil)
+	err := config.validate(true)
+	if err == nil {
+		t.Fatal("expected invalid configuration to be rejected")
+	}
+	for _, problem := range []string{"jpegQuality", "continuation", "twitter.apiKey is required", "twitter.accessTokenSecret is required", "twitter.template"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported, got %v", problem, err)
+		}
+	}
+
+	// credentials are only needed for publishers which are enabled, but one must be
+	config = defaultConfig()
+	config.Twitter.Enabled = false
+	config.resolveAccounts(envMap(nil), nil)
+	if err := config.validate(true); err == nil || strings.Contains(err.Error(), "apiKey") {
+		t.Errorf("expected only the lack of publishers to be reported, got %v", err)
+	}
+}
+
+func TestConfigNames(t *testing.T) {
+	config := defaultConfig()
+	names := map[string]bool{}
+	for _, field := range configFields(reflect.ValueOf(&config).Elem(), nil) {
+		names[field.key+" "+field.flag+" "+field.env] = true
+	}
+	for _, expected := range []string{
+		"twitter.apiKeySecret twitter-api-key-secret WIKICOMMONSPOTD_TWITTER_API_KEY_SECRET",
+		"cacheMaxBytes cache-max-bytes WIKICOMMONSPOTD_CACHE_MAX_BYTES",
+		"feedUrl feed-url WIKICOMMONSPOTD_FEED_URL",
+	} {
+		if !names[expected] {
+			t.Errorf("expected setting %q", expected)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// parseClock parses a time of day given as hh:mm into the time since midnight.
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// nextRun returns when the daemon should next post, given the time of day at which it posts and whether the potd
+// of the current day has already been posted. A run which was missed earlier in the day is made immediately.
+func nextRun(now time.Time, runAt time.Duration, done bool) time.Time {
+	now = now.UTC()
+	scheduled := now.Truncate(24 * time.Hour).Add(runAt)
+	switch {
+	case now.Before(scheduled):
+		return scheduled
+	case done:
+		return scheduled.AddDate(0, 0, 1)
+	default:
+		return now
+	}
+}
+
+// missedDay is a day which the daemon missed, with the accounts which have not completed its potd.
+type missedDay struct {
+	Date     time.Time
+	Accounts []AccountConfig
+}
+
+// missedDays returns the days before today, at most lookback days ago, which were missed: those after the last day
+// on which every account completed the potd, oldest first. An account only misses the days from the first day in
+// its history, so that a new installation, or an account added to an existing one, does not post past potds.
+func missedDays(history *History, accounts []AccountConfig, today time.Time, lookback int) ([]missedDay, error) {
+	entries, err := history.Entries()
+	if err != nil {
+		return nil, err
+	}
+	// dates in this layout sort as strings
+	first := map[string]string{}
+	complete := map[[2]string]bool{}
+	for _, entry := range entries {
+		if date, ok := first[entry.Account]; !ok || entry.Date < date {
+			first[entry.Account] = entry.Date
+		}
+		complete[[2]string{entry.Date, entry.Account}] = entry.Complete
+	}
+
+	var days []missedDay
+	for i := 1; i <= lookback; i++ {
+		date := today.AddDate(0, 0, -i)
+		day := missedDay{Date: date}
+		started := false
+		for _, account := range accounts {
+			if firstDate, ok := first[account.Name]; !ok || firstDate > date.Format(dateLayout) {
+				continue
+			}
+			started = true
+			if !complete[[2]string{date.Format(dateLayout), account.Name}] {
+				day.Accounts = append(day.Accounts, account)
+			}
+		}
+		if !started {
+			// no account had started by this day, nor by any earlier one
+			break
+		}
+		if len(day.Accounts) == 0 {
+			break
+		}
+		days = append([]missedDay{day}, days...)
+	}
+	return days, nil
+}
+
+// feedDays returns the days listed in the feed, which are the only ones whose potd can still be fetched.
+func feedDays(ctx context.Context, config Config) (days map[string]bool, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = panicError(recovered)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.FetchTimeout))
+	defer cancel()
+	days = map[string]bool{}
+	for _, item := range getFeedItems(ctx, config.FeedUrl, config.Accounts[0].Language) {
+		days[item.Date.Format(dateLayout)] = true
+	}
+	return days, nil
+}
+
+// daemon holds what runDaemon keeps between wakes. Its clock and the run it makes each day can be replaced in tests.
+type daemon struct {
+	config  Config
+	history *History
+	runAt   time.Duration
+	now     func() time.Time
+	run     func(ctx context.Context, config Config, history *History, date time.Time) error
+	// caughtUp is set when the days missed before the next wake have already been posted
+	caughtUp bool
+	retryAt  time.Time
+}
+
+// today returns the current Commons day according to the clock of the daemon, at midnight UTC.
+func (d *daemon) today() time.Time {
+	return d.now().UTC().Truncate(24 * time.Hour)
+}
+
+// catchUp posts the potds of the days which the daemon missed, such as while it was not running, which are still in
+// the feed. A day which fails is alerted on like any other run, and tried again when the daemon next wakes.
+func (d *daemon) catchUp(ctx context.Context) {
+	days, err := missedDays(d.history, d.config.Accounts, d.today(), d.config.CatchUpDays)
+	if err != nil {
+		log.WithError(err).Error("could not read history")
+		return
+	}
+	if len(days) == 0 {
+		return
+	}
+	feed, err := feedDays(ctx, d.config)
+	if err != nil {
+		log.WithError(err).Error("could not fetch feed to catch up on missed days")
+		return
+	}
+	for _, day := range days {
+		if ctx.Err() != nil {
+			return
+		}
+		date := day.Date
+		if !feed[date.Format(dateLayout)] {
+			log.WithField("date", date.Format(dateLayout)).Warn("missed potd is no longer in the feed")
+			continue
+		}
+		// only the accounts which missed the day post it
+		dayConfig := d.config
+		dayConfig.Accounts = day.Accounts
+		log.WithFields(log.Fields{"runId": runId.start(), "date": date.Format(dateLayout)}).Info("catching up on missed day")
+		if err := d.run(ctx, dayConfig, d.history, date); err != nil {
+			log.WithError(err).WithField("date", date.Format(dateLayout)).Error("could not post missed potd")
+		}
+	}
+}
+
+// next returns when the daemon should next wake: at the time of day at which it posts, straight away if the potd of
+// the day has not been posted yet, or once RetryInterval has passed since a failed run.
+func (d *daemon) next() time.Time {
+	done, err := d.history.Done(d.today(), d.config.Accounts)
+	if err != nil {
+		log.WithError(err).Error("could not read history")
+	}
+	next := nextRun(d.now(), d.runAt, done)
+	if next.Before(d.retryAt) {
+		next = d.retryAt
+	}
+	return next
+}
+
+// wake posts the days missed since the last wake, then the potd of the day. It returns false if ctx was cancelled
+// during the run, in which case the daemon should shut down.
+func (d *daemon) wake(ctx context.Context) bool {
+	if !d.caughtUp {
+		d.catchUp(ctx)
+	}
+	d.caughtUp = false
+	log.WithField("runId", runId.start()).Info("starting run")
+	if err := d.run(ctx, d.config, d.history, d.today()); ctx.Err() != nil {
+		log.WithError(err).Info("run cancelled, shutting down")
+		return false
+	} else if err != nil {
+		d.retryAt = d.now().Add(time.Duration(d.config.RetryInterval))
+		log.WithError(err).WithField("retryAt", d.retryAt).Error("run failed")
+		return true
+	}
+	d.retryAt = time.Time{}
+	log.Info("run complete")
+	return true
+}
+
+// runDaemon posts the potd every day at the configured time until ctx is cancelled, such as by SIGTERM or SIGINT.
+// On starting, and on every wake before the run of the day, it first posts the days it missed within CatchUpDays.
+// A failed run is retried after RetryInterval, and the history ensures nothing is posted twice when a run is
+// repeated. Cancelling ctx during a run aborts it, and the thread it was posting is continued from the history by
+// the next run.
+func runDaemon(ctx context.Context, config Config, history *History) {
+	runAt, err := parseClock(config.RunAt)
+	if err != nil {
+		log.WithError(err).Panic("invalid time of day to run at")
+	}
+	log.WithField("runAt", config.RunAt).Info("daemon started")
+
+	d := &daemon{config: config, history: history, runAt: runAt, now: time.Now, run: runWithAlerts}
+	d.catchUp(ctx)
+	// a wake straight after starting has nothing more to catch up on
+	d.caughtUp = true
+	for {
+		next := d.next()
+		log.WithField("next", next).Info("waiting for next run")
+
+		timer := time.NewTimer(next.Sub(d.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Info("cancelled, shutting down")
+			return
+		case <-timer.C:
+		}
+
+		if !d.wake(ctx) {
+			return
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextRun(t *testing.T) {
+	runAt := 30 * time.Minute
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		now      time.Time
+		done     bool
+		expected time.Time
+	}{
+		// before the scheduled time, wait for it
+		{day.Add(10 * time.Minute), false, day.Add(runAt)},
+		// after it, run at once unless the day is done
+		{day.Add(5 * time.Hour), false, day.Add(5 * time.Hour)},
+		{day.Add(5 * time.Hour), true, day.AddDate(0, 0, 1).Add(runAt)},
+		// times in other zones are scheduled in UTC
+		{time.Date(2024, 3, 1, 1, 20, 0, 0, time.FixedZone("CET", 3600)), false, day.Add(runAt)},
+	} {
+		if got := nextRun(test.now, runAt, test.done); !got.Equal(test.expected) {
+			t.Errorf("at %v (done %v): expected %v, got %v", test.now, test.done, test.expected, got)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	if got, err := parseClock("15:04"); err != nil || got != 15*time.Hour+4*time.Minute {
+		t.Errorf("unexpected %v (err %v)", got, err)
+	}
+	for _, clock := range []string{"25:00", "3pm", ""} {
+		if _, err := parseClock(clock); err == nil {
+			t.Errorf("expected %q to be rejected", clock)
+		}
+	}
+}
+
+func TestMissedDays(t *testing.T) {
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	accounts := []AccountConfig{{Name: "a"}, {Name: "b"}}
+	history := &History{Path: filepath.Join(t.TempDir(), "history.jsonl")}
+
+	// a new installation has not missed anything
+	if days, err := missedDays(history, accounts, day, 7); err != nil || len(days) != 0 {
+		t.Errorf("expected no missed days without history, got %v (err %v)", days, err)
+	}
+
+	for _, entry := range []HistoryEntry{
+		{Date: "2024-03-06", Account: "a", Complete: true},
+		{Date: "2024-03-06", Account: "b", Complete: true},
+		// only one account completed this day, so it is not done
+		{Date: "2024-03-07", Account: "a", Complete: true},
+	} {
+		if err := history.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		lookback int
+		expected []string
+	}{
+		{7, []string{"2024-03-07 b", "2024-03-08 a,b", "2024-03-09 a,b"}},
+		{2, []string{"2024-03-08 a,b", "2024-03-09 a,b"}},
+		{0, nil},
+	} {
+		days, err := missedDays(history, accounts, day, test.lookback)
+		if got := describeMissedDays(days); err != nil || strings.Join(got, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("lookback %d: expected %v, got %v (err %v)", test.lookback, test.expected, got, err)
+		}
+	}
+}
+
+// describeMissedDays lists each missed day with the accounts which missed it.
+func describeMissedDays(days []missedDay) []string {
+	var described []string
+	for _, day := range days {
+		var names []string
+		for _, account := range day.Accounts {
+			names = append(names, account.Name)
+		}
+		described = append(described, day.Date.Format(dateLayout)+" "+strings.Join(names, ","))
+	}
+	return described
+}
+
+// Test that an account added to an existing installation does not miss the days before its first post.
+func TestMissedDaysNewAccount(t *testing.T) {
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	history := &History{Path: filepath.Join(t.TempDir(), "history.jsonl")}
+	for _, date := range []string{"2024-03-07", "2024-03-08"} {
+		if err := history.Record(HistoryEntry{Date: date, Account: "old", Complete: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	accounts := []AccountConfig{{Name: "old"}, {Name: "new"}}
+
+	days, err := missedDays(history, accounts, day, 7)
+	if got := describeMissedDays(days); err != nil || strings.Join(got, ",") != "2024-03-09 old" {
+		t.Errorf("expected only the old account to miss a day, got %v (err %v)", got, err)
+	}
+
+	// once the new account has posted, it misses the days after its first post like any other
+	if err := history.Record(HistoryEntry{Date: "2024-03-08", Account: "new", Complete: true}); err != nil {
+		t.Fatal(err)
+	}
+	days, err = missedDays(history, accounts, day, 7)
+	if got := describeMissedDays(days); err != nil || strings.Join(got, ",") != "2024-03-09 old,new" {
+		t.Errorf("expected both accounts to miss the last day, got %v (err %v)", got, err)
+	}
+}
+
+// Test the wakes of the daemon on a fake clock: catching up on missed days before the run of the day, retrying a
+// failed run after RetryInterval, and shutting down when cancelled during a run.
+func TestDaemonWake(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Picture of the day</title>` +
+			`<item><title>9 March</title><pubDate>Sat, 09 Mar 2024 00:00:00 GMT</pubDate><description>&lt;p&gt;ninth&lt;/p&gt;</description></item>` +
+			`<item><title>10 March</title><pubDate>Sun, 10 Mar 2024 00:00:00 GMT</pubDate><description>&lt;p&gt;tenth&lt;/p&gt;</description></item>` +
+			`</channel></rss>`))
+	}))
+	defer server.Close()
+
+	config := defaultConfig()
+	config.FeedUrl = server.URL
+	config.Accounts = []AccountConfig{{Name: "a", Language: "en"}}
+	config.RetryInterval = Duration(30 * time.Minute)
+	history := &History{Path: filepath.Join(t.TempDir(), "history.jsonl")}
+	if err := history.Record(HistoryEntry{Date: "2024-03-08", Account: "a", Complete: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 3, 10, 1, 0, 0, 0, time.UTC)
+	var runs []string
+	var runErr error
+	d := &daemon{config: config, history: history, runAt: 30 * time.Minute, now: func() time.Time { return now },
+		run: func(ctx context.Context, config Config, history *History, date time.Time) error {
+			runs = append(runs, date.Format(dateLayout))
+			if runErr != nil {
+				return runErr
+			}
+			for _, account := range config.Accounts {
+				if err := history.Record(HistoryEntry{Date: date.Format(dateLayout), Account: account.Name, Complete: true}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}}
+	wake := func(description string, expectedRuns []string, expectedNext time.Time) {
+		t.Helper()
+		runs = nil
+		if !d.wake(context.Background()) {
+			t.Fatalf("%s: expected the daemon to carry on", description)
+		}
+		if strings.Join(runs, ",") != strings.Join(expectedRuns, ",") {
+			t.Errorf("%s: expected runs %v, got %v", description, expectedRuns, runs)
+		}
+		if next := d.next(); !next.Equal(expectedNext) {
+			t.Errorf("%s: expected next wake at %v, got %v", description, expectedNext, next)
+		}
+	}
+
+	// the daemon was down on the ninth, and wakes late on the tenth
+	wake("catch up", []string{"2024-03-09", "2024-03-10"}, time.Date(2024, 3, 11, 0, 30, 0, 0, time.UTC))
+
+	now = time.Date(2024, 3, 11, 0, 30, 0, 0, time.UTC)
+	runErr = errors.New("twitter is down")
+	wake("failed run", []string{"2024-03-11"}, now.Add(30*time.Minute))
+
+	now = now.Add(30 * time.Minute)
+	runErr = nil
+	wake("retried run", []string{"2024-03-11"}, time.Date(2024, 3, 12, 0, 30, 0, 0, time.UTC))
+
+	now = time.Date(2024, 3, 12, 0, 30, 0, 0, time.UTC)
+	ctx, cancel := context.WithCancel(context.Background())
+	d.run = func(ctx context.Context, config Config, history *History, date time.Time) error {
+		cancel()
+		return ctx.Err()
+	}
+	if d.wake(ctx) {
+		t.Error("expected the daemon to shut down when cancelled during a run")
+	}
+}
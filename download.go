@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// commonsApiUrl is the MediaWiki action API endpoint of Wikimedia Commons.
+var commonsApiUrl = "https://commons.wikimedia.org/w/api.php"
+
+// ImageInfo holds the properties of a Commons file reported by the imageinfo API.
+type ImageInfo struct {
+	// PageId identifies the file description page, and so the MediaInfo entity of the file.
+	PageId int64  `json:"pageid"`
+	Url    string `json:"url"`
+	Size   int64  `json:"size"`
+	Sha1   string `json:"sha1"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Artist, Licence and LicenceUrl are taken from the extended metadata, with the artist converted to plain text.
+	Artist     string `json:"artist"`
+	Licence    string `json:"licence"`
+	LicenceUrl string `json:"licenceUrl"`
+	// Coordinates are taken from GPSLatitude and GPSLongitude in the extended metadata, or nil if there are none.
+	Coordinates *Coordinates `json:"coordinates,omitempty"`
+}
+
+// DownloadOptions control how downloadFile fetches the potd image.
+type DownloadOptions struct {
+	// Timeout bounds the whole download, including any resumed attempts.
+	Timeout time.Duration
+	// MaxBytes is the largest file which will be accepted.
+	MaxBytes int64
+	// MaxAttempts is the number of requests made before giving up on an interrupted transfer.
+	MaxAttempts int
+	// Sha1 is the expected hex digest of the file, or empty to skip verification.
+	Sha1 string
+}
+
+func getImageInfo(ctx context.Context, fileName string) ImageInfo {
+	query := url.Values{
+		"action":              {"query"},
+		"format":              {"json"},
+		"prop":                {"imageinfo"},
+		"iiprop":              {"url|size|sha1|extmetadata"},
+		"iiextmetadatafilter": {"Artist|LicenseShortName|LicenseUrl|GPSLatitude|GPSLongitude"},
+		"titles":              {"File:" + fileName},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, commonsApiUrl+"?"+query.Encode(), nil)
+	if err != nil {
+		log.WithError(err).Panic("unable to create request for imageinfo")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.WithError(err).Panic("unable to retrieve imageinfo via http")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.WithField("statusCode", resp.StatusCode).Panic("bad http status while retrieving imageinfo")
+	}
+
+	// the response is keyed by page id, which we do not know in advance
+	type ExtMetadata map[string]struct {
+		Value string `json:"value"`
+	}
+	type ImageInfoResponse struct {
+		Query struct {
+			Pages map[string]struct {
+				PageId    int64 `json:"pageid"`
+				ImageInfo []struct {
+					ImageInfo
+					ExtMetadata ExtMetadata `json:"extmetadata"`
+				} `json:"imageinfo"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	var info ImageInfoResponse
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		log.WithError(err).Panic("unable to decode imageinfo response")
+	}
+
+	for _, page := range info.Query.Pages {
+		if len(page.ImageInfo) > 0 {
+			result := page.ImageInfo[0]
+			result.Artist = plainTextFromHTML(result.ExtMetadata["Artist"].Value)
+			result.Licence = result.ExtMetadata["LicenseShortName"].Value
+			result.LicenceUrl = result.ExtMetadata["LicenseUrl"].Value
+			result.Coordinates = parseExtMetadataCoordinates(result.ExtMetadata["GPSLatitude"].Value, result.ExtMetadata["GPSLongitude"].Value)
+			result.PageId = page.PageId
+			return result.ImageInfo
+		}
+	}
+	log.WithField("fileName", fileName).Panic("imageinfo response did not describe the file")
+	return ImageInfo{}
+}
+
+// errDownloadTooLarge is returned when the file exceeds DownloadOptions.MaxBytes.
+var errDownloadTooLarge = errors.New("download exceeds maximum size")
+
+// errRangeMismatch is returned when the server resumes a download from another offset than the one requested, so
+// that the download has to start again from the beginning.
+var errRangeMismatch = errors.New("server resumed download from the wrong offset")
+
+// downloadRetryDelay is the time waited before retrying a download which got an error status, multiplied by the
+// number of attempts so far.
+var downloadRetryDelay = 2 * time.Second
+
+// httpStatusError is returned when the server responds with a status other than the content requested.
+type httpStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("bad http status %d: %s", e.StatusCode, e.Body)
+}
+
+// permanent reports whether repeating the request cannot help, as for a client error other than a timeout or a
+// rate limit.
+func (e *httpStatusError) permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
+// contentRangeStart returns the first byte of the range in a Content-Range header such as "bytes 100-199/200".
+func contentRangeStart(header string) (int64, bool) {
+	var start int64
+	_, err := fmt.Sscanf(header, "bytes %d-", &start)
+	return start, err == nil
+}
+
+// downloadAttempt requests the remainder of the file from the given offset and appends it to the file,
+// returning the number of bytes written.
+func downloadAttempt(ctx context.Context, file *os.File, url string, offset int64, maxBytes int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// the server is resuming, which is only of use if it resumes where we left off
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			log.WithFields(log.Fields{"offset": offset, "contentRange": resp.Header.Get("Content-Range")}).Warn("server resumed download from the wrong offset, restarting download")
+			if err := file.Truncate(0); err != nil {
+				return 0, err
+			}
+			return 0, errRangeMismatch
+		}
+	case http.StatusOK:
+		// the server does not support ranges, or this is the first attempt, so start from scratch
+		if offset > 0 {
+			log.Warn("server ignored range request, restarting download")
+		}
+		offset = 0
+		if err := file.Truncate(0); err != nil {
+			return 0, err
+		}
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return offset, &httpStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	if resp.ContentLength > 0 && offset+resp.ContentLength > maxBytes {
+		return 0, errDownloadTooLarge
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	// read at most one byte more than permitted, so that an oversized body without a content length is detected
+	written, err := io.Copy(file, io.LimitReader(resp.Body, maxBytes-offset+1))
+	downloadBytes.Add(float64(written))
+	if offset+written > maxBytes {
+		return offset + written, errDownloadTooLarge
+	}
+	return offset + written, err
+}
+
+// downloadFile saves the file at the url to the provided file, resuming with range requests if the transfer
+// is interrupted, and verifies its SHA-1 digest if one is given.
+func downloadFile(ctx context.Context, file *os.File, url string, opts DownloadOptions) {
+	ctx, span, end := startSpan(ctx, "downloadFile", attribute.String("url", url))
+	defer end()
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	var size int64
+	for attempt := 1; ; attempt++ {
+		var err error
+		size, err = downloadAttempt(ctx, file, url, size, opts.MaxBytes)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, errDownloadTooLarge) {
+			log.WithFields(log.Fields{"url": url, "maxBytes": opts.MaxBytes}).Panic("potd image exceeds maximum download size")
+		}
+		fields := log.Fields{"url": url, "attempts": attempt}
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) {
+			fields["statusCode"] = statusErr.StatusCode
+			if statusErr.permanent() {
+				log.WithError(err).WithFields(fields).Panic("bad http status while downloading potd image")
+			}
+		}
+		if attempt >= opts.MaxAttempts || ctx.Err() != nil {
+			log.WithError(err).WithFields(fields).Panic("could not download potd image via http")
+		}
+		log.WithError(err).WithFields(log.Fields{"attempt": attempt, "bytesSoFar": size}).Warn("download interrupted, resuming")
+
+		// a server which is overloaded or limiting our rate needs time before it will answer
+		if statusErr != nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(attempt) * downloadRetryDelay):
+			}
+		}
+	}
+	log.WithFields(log.Fields{"url": url, "size": size}).Info("download complete")
+	span.SetAttributes(attribute.Int64("size", size))
+
+	if opts.Sha1 == "" {
+		return
+	}
+
+	// hash the file as it is on disk, since it may have been assembled over several attempts
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		log.WithError(err).WithField("path", file.Name()).Panic("could not rewind potd image file")
+	}
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		log.WithError(err).WithField("path", file.Name()).Panic("could not read potd image file to verify it")
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(digest, opts.Sha1) {
+		log.WithFields(log.Fields{"expected": opts.Sha1, "actual": digest}).Panic("SHA-1 of downloaded potd image does not match Commons")
+	}
+	log.WithField("sha1", digest).Info("verified SHA-1 of downloaded potd image")
+}
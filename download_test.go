@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// assertPanics fails the test if f returns without panicking.
+func assertPanics(t *testing.T, description string, f func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic: %s", description)
+		}
+	}()
+	f()
+}
+
+// Serve content which is cut off halfway through the first request, and served normally with range support afterwards.
+func flakyServer(content []byte) *httptest.Server {
+	requests := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			// abandon the connection so that the client sees an unexpected EOF
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "potd.jpg", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	content := bytes.Repeat([]byte("picture of the day "), 1000)
+	digest := sha1.Sum(content)
+	server := flakyServer(content)
+	defer server.Close()
+
+	file, err := os.CreateTemp(t.TempDir(), "potdFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	downloadFile(context.Background(), file, server.URL, DownloadOptions{Timeout: time.Minute, MaxBytes: 1000000, MaxAttempts: 3, Sha1: hex.EncodeToString(digest[:])})
+	got, err := os.ReadFile(file.Name())
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("expected resumed download to match original content, got %d bytes (err %v)", len(got), err)
+	}
+}
+
+func TestDownloadFileRejects(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 10000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "potd.jpg", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	file, err := os.CreateTemp(t.TempDir(), "potdFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	assertPanics(t, "file larger than cap", func() {
+		downloadFile(context.Background(), file, server.URL, DownloadOptions{Timeout: time.Minute, MaxBytes: 5000, MaxAttempts: 1})
+	})
+	assertPanics(t, "checksum mismatch", func() {
+		downloadFile(context.Background(), file, server.URL, DownloadOptions{Timeout: time.Minute, MaxBytes: 1000000, MaxAttempts: 1, Sha1: "0000000000000000000000000000000000000000"})
+	})
+}
+
+func TestGetImageInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("titles") != "File:Example.jpg" {
+			t.Errorf("unexpected titles parameter %q", r.URL.Query().Get("titles"))
+		}
+		w.Write([]byte(`{"batchcomplete":"","query":{"pages":{"123":{"pageid":123,"ns":6,"title":"File:Example.jpg","imagerepository":"local","imageinfo":[{"size":4567,"width":640,"height":480,"url":"https://upload.wikimedia.org/wikipedia/commons/a/a9/Example.jpg","sha1":"da39a3ee5e6b4b0d3255bfef95601890afd80709","extmetadata":{"Artist":{"value":"<a href=\"//commons.wikimedia.org/wiki/User:Example\" title=\"User:Example\">Example</a>","source":"commons-desc-page"},"LicenseShortName":{"value":"CC BY-SA 4.0","source":"commons-desc-page"},"LicenseUrl":{"value":"https://creativecommons.org/licenses/by-sa/4.0","source":"commons-desc-page"},"GPSLatitude":{"value":"40.781200","source":"commons-desc-page"},"GPSLongitude":{"value":"-73.966500","source":"commons-desc-page"}}}]}}}}`))
+	}))
+	defer server.Close()
+	defer func(previous string) { commonsApiUrl = previous }(commonsApiUrl)
+	commonsApiUrl = server.URL
+
+	info := getImageInfo(context.Background(), "Example.jpg")
+	expected := ImageInfo{
+		PageId:      123,
+		Url:         "https://upload.wikimedia.org/wikipedia/commons/a/a9/Example.jpg",
+		Size:        4567,
+		Sha1:        "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		Width:       640,
+		Height:      480,
+		Artist:      "Example",
+		Licence:     "CC BY-SA 4.0",
+		LicenceUrl:  "https://creativecommons.org/licenses/by-sa/4.0",
+		Coordinates: &Coordinates{Latitude: 40.7812, Longitude: -73.9665},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("expected %v, got %v", expected, info)
+	}
+}
+
+// Test that a server error is retried, while a client error other than a rate limit ends the download at once.
+func TestDownloadFileStatus(t *testing.T) {
+	defer func(previous time.Duration) { downloadRetryDelay = previous }(downloadRetryDelay)
+	downloadRetryDelay = time.Millisecond
+	content := []byte("picture of the day")
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/missing.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if requests <= len(statuses) {
+			w.WriteHeader(statuses[requests-1])
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	file, err := os.CreateTemp(t.TempDir(), "potdFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	downloadFile(context.Background(), file, server.URL+"/potd.jpg", DownloadOptions{Timeout: time.Minute, MaxBytes: 1000, MaxAttempts: 3})
+	if got, _ := os.ReadFile(file.Name()); !bytes.Equal(got, content) {
+		t.Errorf("expected the download to succeed on the third attempt, got %q", got)
+	}
+
+	requests = 0
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		downloadFile(context.Background(), file, server.URL+"/missing.jpg", DownloadOptions{Timeout: time.Minute, MaxBytes: 1000, MaxAttempts: 3})
+	}()
+	if recovered == nil || errorKind(recovered) != "http" || requests != 1 {
+		t.Errorf("expected a single request failing with an http error, got %d requests and %v", requests, recovered)
+	}
+}
+
+// Test that a download which is resumed from the wrong offset starts again from the beginning.
+func TestDownloadFileRangeMismatch(t *testing.T) {
+	content := bytes.Repeat([]byte("picture of the day "), 1000)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case 2:
+			// resume from the start of the file rather than where the client left off
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content)
+		default:
+			w.Write(content)
+		}
+	}))
+	defer server.Close()
+
+	file, err := os.CreateTemp(t.TempDir(), "potdFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	digest := sha1.Sum(content)
+	downloadFile(context.Background(), file, server.URL, DownloadOptions{Timeout: time.Minute, MaxBytes: 1000000, MaxAttempts: 3, Sha1: hex.EncodeToString(digest[:])})
+	if got, _ := os.ReadFile(file.Name()); !bytes.Equal(got, content) || requests != 3 {
+		t.Errorf("expected the download to restart, got %d bytes after %d requests", len(got), requests)
+	}
+}
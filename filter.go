@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// pictureOrientation returns "landscape" or "portrait" for a picture of the given size, or an empty string if it is unknown.
+// Square pictures count as landscape.
+func pictureOrientation(width int, height int) string {
+	switch {
+	case width <= 0 || height <= 0:
+		return ""
+	case width >= height:
+		return "landscape"
+	default:
+		return "portrait"
+	}
+}
+
+// containsFold reports whether any of the names contains any of the substrings, ignoring case,
+// and returns the first such name.
+func containsFold(names []string, substrings []string) (string, bool) {
+	for _, name := range names {
+		for _, substring := range substrings {
+			if strings.Contains(strings.ToLower(name), strings.ToLower(substring)) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Match returns an empty string if a file with the given imageinfo, categories and depicted items passes the filter,
+// and otherwise the reason it does not.
+func (filter FilterConfig) Match(info ImageInfo, categories []string, depicts []DepictedItem) string {
+	if filter.Orientation != "" {
+		orientation := pictureOrientation(info.Width, info.Height)
+		if orientation == "" {
+			return "the size of the picture is unknown"
+		}
+		if orientation != filter.Orientation {
+			return fmt.Sprintf("the picture is %s", orientation)
+		}
+	}
+
+	if category, ok := containsFold(categories, filter.ExcludeCategories); ok {
+		return fmt.Sprintf("the file is in the excluded category %q", category)
+	}
+	if len(filter.Categories) > 0 {
+		if _, ok := containsFold(categories, filter.Categories); !ok {
+			return "the file is in none of the categories"
+		}
+	}
+
+	if len(filter.Depicts) > 0 {
+		depicted := slices.ContainsFunc(depicts, func(item DepictedItem) bool {
+			return slices.Contains(filter.Depicts, item.Id)
+		})
+		if !depicted {
+			return "the file depicts none of the items"
+		}
+	}
+	return ""
+}
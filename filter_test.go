@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	landscape := ImageInfo{Width: 640, Height: 480}
+	categories := []string{"Pictures of the day", "Sphyrapicus varius (male)", "Central Park"}
+	depicts := []DepictedItem{{Id: "Q1043301", Label: "yellow-bellied sapsucker"}, {Id: "Q60", Label: "New York City"}}
+
+	for _, test := range []struct {
+		filter FilterConfig
+		info   ImageInfo
+		passes bool
+	}{
+		{FilterConfig{}, ImageInfo{}, true},
+		{FilterConfig{Orientation: "landscape"}, landscape, true},
+		{FilterConfig{Orientation: "portrait"}, landscape, false},
+		{FilterConfig{Orientation: "portrait"}, ImageInfo{Width: 480, Height: 640}, true},
+		{FilterConfig{Orientation: "landscape"}, ImageInfo{}, false},
+		{FilterConfig{Categories: []string{"central park"}}, landscape, true},
+		{FilterConfig{Categories: []string{"Architecture", "Sphyrapicus"}}, landscape, true},
+		{FilterConfig{Categories: []string{"Architecture"}}, landscape, false},
+		{FilterConfig{ExcludeCategories: []string{"park"}}, landscape, false},
+		{FilterConfig{Categories: []string{"Sphyrapicus"}, ExcludeCategories: []string{"female"}}, landscape, true},
+		{FilterConfig{Depicts: []string{"Q5", "Q60"}}, landscape, true},
+		{FilterConfig{Depicts: []string{"Q5"}}, landscape, false},
+	} {
+		reason := test.filter.Match(test.info, categories, depicts)
+		if (reason == "") != test.passes {
+			t.Errorf("filter %+v on %dx%d: expected pass %v, got reason %q", test.filter, test.info.Width, test.info.Height, test.passes, reason)
+		}
+	}
+}
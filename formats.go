@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Image formats recognised by detectFormat.
+const (
+	FormatJPEG    = "jpeg"
+	FormatPNG     = "png"
+	FormatGIF     = "gif"
+	FormatWebP    = "webp"
+	FormatTIFF    = "tiff"
+	FormatSVG     = "svg"
+	FormatPDF     = "pdf"
+	FormatDjVu    = "djvu"
+	FormatUnknown = "unknown"
+)
+
+// detectFormat identifies the format of an encoded file from its leading bytes.
+func detectFormat(buf []byte) string {
+	switch {
+	case bytes.HasPrefix(buf, []byte{0xff, 0xd8, 0xff}):
+		return FormatJPEG
+	case bytes.HasPrefix(buf, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG
+	case bytes.HasPrefix(buf, []byte("GIF87a")), bytes.HasPrefix(buf, []byte("GIF89a")):
+		return FormatGIF
+	case len(buf) >= 12 && string(buf[:4]) == "RIFF" && string(buf[8:12]) == "WEBP":
+		return FormatWebP
+	case bytes.HasPrefix(buf, []byte("II*\x00")), bytes.HasPrefix(buf, []byte("MM\x00*")):
+		return FormatTIFF
+	case bytes.HasPrefix(buf, []byte("%PDF-")):
+		return FormatPDF
+	case bytes.HasPrefix(buf, []byte("AT&TFORM")):
+		return FormatDjVu
+	}
+
+	// svg is text, so look for the root element near the start of the file, after any xml prolog or comments
+	head := buf
+	if len(head) > 4096 {
+		head = head[:4096]
+	}
+	if bytes.Contains(head, []byte("<svg")) {
+		return FormatSVG
+	}
+	return FormatUnknown
+}
+
+// formatFromFileName guesses the format of a Commons file from its extension.
+func formatFromFileName(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".jpg", ".jpeg":
+		return FormatJPEG
+	case ".png":
+		return FormatPNG
+	case ".gif":
+		return FormatGIF
+	case ".webp":
+		return FormatWebP
+	case ".tif", ".tiff":
+		return FormatTIFF
+	case ".svg":
+		return FormatSVG
+	case ".pdf":
+		return FormatPDF
+	case ".djvu", ".djv":
+		return FormatDjVu
+	}
+	return FormatUnknown
+}
+
+// renderedUrl returns the url of a raster rendition of a Commons file produced by the thumbnailer, for formats
+// which cannot be posted or decoded directly, or else the url of the original file. SVG files are rasterised to PNG
+// at the given width, and the first page of PDF, DjVu and TIFF files is rendered to JPEG at the given width or the
+// width of the original, whichever is smaller, since the thumbnailer will not enlarge them.
+func renderedUrl(info ImageInfo, width int) string {
+	original, err := url.Parse(info.Url)
+	if err != nil {
+		return info.Url
+	}
+	// original urls have the form .../commons/a/ab/File.ext, and their thumbnails .../commons/thumb/a/ab/File.ext/123px-File.ext
+	segments := strings.Split(original.EscapedPath(), "/")
+	if len(segments) < 4 {
+		return info.Url
+	}
+	fileName := segments[len(segments)-1]
+
+	format := formatFromFileName(path.Base(original.Path))
+	if format != FormatSVG && info.Width > 0 && width > info.Width {
+		width = info.Width
+	}
+	var thumbName string
+	px := strconv.Itoa(width) + "px-" + fileName
+	switch format {
+	case FormatSVG:
+		thumbName = px + ".png"
+	case FormatPDF, FormatDjVu:
+		thumbName = "page1-" + px + ".jpg"
+	case FormatTIFF:
+		thumbName = "lossy-page1-" + px + ".jpg"
+	default:
+		return info.Url
+	}
+
+	base := strings.Join(segments[:len(segments)-3], "/")
+	hashDirs := strings.Join(segments[len(segments)-3:len(segments)-1], "/")
+	rendered := *original
+	rendered.RawPath = base + "/thumb/" + hashDirs + "/" + fileName + "/" + thumbName
+	rendered.Path, _ = url.PathUnescape(rendered.RawPath)
+	return rendered.String()
+}
+
+// colourKey quantises a colour to 5 bits per channel, so that minor noise does not produce distinct colours.
+func colourKey(c color.Color) uint16 {
+	r, g, b, _ := c.RGBA()
+	return uint16(r>>11)<<10 | uint16(g>>11)<<5 | uint16(b>>11)
+}
+
+// isLineArt reports whether an image consists mostly of a handful of flat colours, like a diagram, map or logo,
+// and would therefore compress better and look sharper as a PNG than as a JPEG.
+func isLineArt(backend ImageBackend, buf []byte, background color.RGBA) (bool, error) {
+	// analyse a small flattened preview rather than the full image
+	preview, err := backend.Resize(buf, 128, EncodeOptions{Format: FormatPNG, Background: background})
+	if err != nil {
+		return false, err
+	}
+	img, err := png.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return false, err
+	}
+
+	counts := map[uint16]int{}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			counts[colourKey(img.At(x, y))]++
+		}
+	}
+
+	// sum the pixels covered by the most common colours
+	frequencies := make([]int, 0, len(counts))
+	for _, count := range counts {
+		frequencies = append(frequencies, count)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(frequencies)))
+	covered := 0
+	for i := 0; i < len(frequencies) && i < lineArtColours; i++ {
+		covered += frequencies[i]
+	}
+
+	total := bounds.Dx() * bounds.Dy()
+	return float64(covered) >= lineArtCoverage*float64(total), nil
+}
+
+// an image is considered line art if its lineArtColours most common colours cover at least lineArtCoverage of its pixels
+const (
+	lineArtColours  = 8
+	lineArtCoverage = 0.85
+)
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]string{
+		"\xff\xd8\xff\xe0\x00\x10JFIF":                    FormatJPEG,
+		"\x89PNG\r\n\x1a\n\x00\x00":                       FormatPNG,
+		"GIF89a\x01\x00":                                  FormatGIF,
+		"RIFF\x00\x00\x00\x00WEBPVP8 ":                    FormatWebP,
+		"II*\x00\x08\x00\x00\x00":                         FormatTIFF,
+		"MM\x00*\x00\x00\x00\x08":                         FormatTIFF,
+		"%PDF-1.7\n":                                      FormatPDF,
+		"AT&TFORM\x00\x00":                                FormatDjVu,
+		"<?xml version=\"1.0\"?>\n<!-- x -->\n<svg xmlns": FormatSVG,
+		"hello world":                                     FormatUnknown,
+	}
+	for input, expected := range cases {
+		if got := detectFormat([]byte(input)); got != expected {
+			t.Errorf("detectFormat(%q) = %s, expected %s", input, got, expected)
+		}
+	}
+}
+
+func TestRenderedUrl(t *testing.T) {
+	base := "https://upload.wikimedia.org/wikipedia/commons/thumb/a/ab/"
+	cases := []struct {
+		fileName string
+		width    int
+		expected string
+	}{
+		{"Photo.jpg", 10000, "https://upload.wikimedia.org/wikipedia/commons/a/ab/Photo.jpg"},
+		{"Diagram.svg", 10000, base + "Diagram.svg/2048px-Diagram.svg.png"},
+		// small vector images are rasterised at the full width
+		{"Diagram.svg", 512, base + "Diagram.svg/2048px-Diagram.svg.png"},
+		{"Scan.pdf", 10000, base + "Scan.pdf/page1-2048px-Scan.pdf.jpg"},
+		{"Book.djvu", 10000, base + "Book.djvu/page1-2048px-Book.djvu.jpg"},
+		{"Map.tif", 10000, base + "Map.tif/lossy-page1-2048px-Map.tif.jpg"},
+		// the thumbnailer does not enlarge raster renditions
+		{"Map.tif", 1200, base + "Map.tif/lossy-page1-1200px-Map.tif.jpg"},
+		{"Caf%C3%A9_menu.pdf", 10000, base + "Caf%C3%A9_menu.pdf/page1-2048px-Caf%C3%A9_menu.pdf.jpg"},
+	}
+	for _, c := range cases {
+		info := ImageInfo{Url: "https://upload.wikimedia.org/wikipedia/commons/a/ab/" + c.fileName, Width: c.width}
+		if got := renderedUrl(info, 2048); got != c.expected {
+			t.Errorf("renderedUrl for %s at width %d = %s, expected %s", c.fileName, c.width, got, c.expected)
+		}
+	}
+}
+
+func TestIsLineArt(t *testing.T) {
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	backend := newImageBackend()
+
+	// a two-colour diagram on a transparent background
+	diagram := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for x := 50; x < 250; x++ {
+		for y := 90; y < 110; y++ {
+			diagram.Set(x, y, color.NRGBA{R: 0, G: 0, B: 200, A: 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, diagram); err != nil {
+		t.Fatal(err)
+	}
+	lineArt, err := isLineArt(backend, buf.Bytes(), white)
+	if err != nil || !lineArt {
+		t.Errorf("expected diagram to be line art, got %t (err %v)", lineArt, err)
+	}
+
+	lineArt, err = isLineArt(backend, noisePng(t, 300, 200), white)
+	if err != nil || lineArt {
+		t.Errorf("expected noise not to be line art, got %t (err %v)", lineArt, err)
+	}
+}
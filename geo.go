@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bundledGazetteer is the gazetteer used unless another is given, small enough to be built into the binary.
+//
+//go:embed gazetteer.tsv
+var bundledGazetteer string
+
+// earthRadiusKm is the mean radius of the Earth.
+const earthRadiusKm = 6371.0
+
+// String formats coordinates for posts, such as "40.7812°N 73.9665°W", or as nothing if they are unknown.
+func (c *Coordinates) String() string {
+	if c == nil {
+		return ""
+	}
+	latitude, longitude := "N", "E"
+	if c.Latitude < 0 {
+		latitude = "S"
+	}
+	if c.Longitude < 0 {
+		longitude = "W"
+	}
+	return fmt.Sprintf("%.4f°%s %.4f°%s", math.Abs(c.Latitude), latitude, math.Abs(c.Longitude), longitude)
+}
+
+// distanceKm returns the great-circle distance between two points, using the haversine formula.
+func distanceKm(a Coordinates, b Coordinates) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	dLatitude := toRadians(b.Latitude - a.Latitude)
+	dLongitude := toRadians(b.Longitude - a.Longitude)
+	h := math.Pow(math.Sin(dLatitude/2), 2) + math.Cos(toRadians(a.Latitude))*math.Cos(toRadians(b.Latitude))*math.Pow(math.Sin(dLongitude/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+// Place is a named location from a gazetteer.
+type Place struct {
+	Name    string
+	Country string
+	Coordinates
+}
+
+// String formats the place for posts, such as "New York City, United States".
+func (p Place) String() string {
+	if p.Country == "" {
+		return p.Name
+	}
+	return p.Name + ", " + p.Country
+}
+
+// Gazetteer is a list of places which coordinates can be looked up in without any network access.
+type Gazetteer []Place
+
+// geoNamesColumns is the number of columns in the cities files published by GeoNames.
+const geoNamesColumns = 19
+
+// parseGazetteer reads a gazetteer with the name, country, latitude and longitude of each place separated by tabs,
+// one place per line, ignoring blank lines and those starting with "#". GeoNames cities files are also accepted,
+// in which case the country is given by its ISO code.
+func parseGazetteer(r io.Reader) (Gazetteer, error) {
+	var gazetteer Gazetteer
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		columns := strings.Split(text, "\t")
+		var name, country, latitude, longitude string
+		switch len(columns) {
+		case 4:
+			name, country, latitude, longitude = columns[0], columns[1], columns[2], columns[3]
+		case geoNamesColumns:
+			name, country, latitude, longitude = columns[1], columns[8], columns[4], columns[5]
+		default:
+			return nil, fmt.Errorf("line %d: expected 4 or %d columns, got %d", line, geoNamesColumns, len(columns))
+		}
+
+		place := Place{Name: name, Country: country}
+		var err error
+		if place.Latitude, err = strconv.ParseFloat(latitude, 64); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if place.Longitude, err = strconv.ParseFloat(longitude, 64); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		gazetteer = append(gazetteer, place)
+	}
+	return gazetteer, scanner.Err()
+}
+
+// loadGazetteer reads the gazetteer at the given path, or the bundled one if the path is empty.
+func loadGazetteer(path string) Gazetteer {
+	var r io.Reader = strings.NewReader(bundledGazetteer)
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.WithError(err).WithField("path", path).Panic("could not open gazetteer")
+		}
+		defer file.Close()
+		r = file
+	}
+	gazetteer, err := parseGazetteer(r)
+	if err != nil {
+		log.WithError(err).WithField("path", path).Panic("could not parse gazetteer")
+	}
+	return gazetteer
+}
+
+// Nearest returns the place closest to the coordinates, if there is one within maxDistanceKm.
+func (gazetteer Gazetteer) Nearest(coordinates Coordinates, maxDistanceKm float64) (Place, bool) {
+	var nearest Place
+	nearestDistance := math.Inf(1)
+	for _, place := range gazetteer {
+		if distance := distanceKm(coordinates, place.Coordinates); distance < nearestDistance {
+			nearest, nearestDistance = place, distance
+		}
+	}
+	return nearest, nearestDistance <= maxDistanceKm
+}
+
+// parseExtMetadataCoordinates returns the coordinates in the GPSLatitude and GPSLongitude extended metadata of a file,
+// which Commons takes from its location template or EXIF, or nil if there are none.
+func parseExtMetadataCoordinates(latitude string, longitude string) *Coordinates {
+	if latitude == "" || longitude == "" {
+		return nil
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latitude), 64)
+	if err != nil {
+		log.WithError(err).WithField("latitude", latitude).Warn("could not parse latitude of file")
+		return nil
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(longitude), 64)
+	if err != nil {
+		log.WithError(err).WithField("longitude", longitude).Warn("could not parse longitude of file")
+		return nil
+	}
+	return &Coordinates{Latitude: lat, Longitude: lon}
+}
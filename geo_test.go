@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestBundledGazetteer(t *testing.T) {
+	gazetteer := loadGazetteer("")
+	if len(gazetteer) < 100 {
+		t.Fatalf("expected the bundled gazetteer to have many places, got %d", len(gazetteer))
+	}
+
+	centralPark := Coordinates{Latitude: 40.7812, Longitude: -73.9665}
+	if place, ok := gazetteer.Nearest(centralPark, 50); !ok || place.String() != "New York City, United States" {
+		t.Errorf("expected New York City, got %v (found %v)", place, ok)
+	}
+	southPacific := Coordinates{Latitude: -48.8767, Longitude: -123.3933}
+	if place, ok := gazetteer.Nearest(southPacific, 50); ok {
+		t.Errorf("expected no place near the oceanic pole of inaccessibility, got %v", place)
+	}
+}
+
+func TestParseGazetteer(t *testing.T) {
+	geoNames := "5128581\tNew York City\tNew York City\tNYC\t40.71427\t-74.00597\tP\tPPL\tUS\t\tNY\t\t\t\t8804190\t10\t57\tAmerica/New_York\t2024-01-01"
+	gazetteer, err := parseGazetteer(strings.NewReader("# comment\n\nParis\tFrance\t48.8566\t2.3522\n" + geoNames + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Gazetteer{
+		{Name: "Paris", Country: "France", Coordinates: Coordinates{Latitude: 48.8566, Longitude: 2.3522}},
+		{Name: "New York City", Country: "US", Coordinates: Coordinates{Latitude: 40.71427, Longitude: -74.00597}},
+	}
+	if len(gazetteer) != len(expected) || gazetteer[0] != expected[0] || gazetteer[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, gazetteer)
+	}
+
+	for _, text := range []string{"Paris\tFrance\t48.8566", "Paris\tFrance\tnorth\t2.3522"} {
+		if _, err := parseGazetteer(strings.NewReader(text)); err == nil {
+			t.Errorf("expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestDistanceKm(t *testing.T) {
+	london := Coordinates{Latitude: 51.5074, Longitude: -0.1278}
+	paris := Coordinates{Latitude: 48.8566, Longitude: 2.3522}
+	if distance := distanceKm(london, paris); math.Abs(distance-344) > 2 {
+		t.Errorf("expected London to be about 344km from Paris, got %.1f", distance)
+	}
+}
+
+func TestCoordinatesString(t *testing.T) {
+	if got := (&Coordinates{Latitude: 40.7812, Longitude: -73.9665}).String(); got != "40.7812°N 73.9665°W" {
+		t.Errorf("unexpected coordinates %q", got)
+	}
+	if got := (&Coordinates{Latitude: -33.8688, Longitude: 151.2093}).String(); got != "33.8688°S 151.2093°E" {
+		t.Errorf("unexpected coordinates %q", got)
+	}
+
+	// unknown coordinates print as nothing in templates
+	tmpl, err := parsePostTemplate("test", "{{.Description}} {{.Coordinates}} {{.Place}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := composePost(tmpl, PostData{PotdEntry: PotdEntry{Description: "A woodpecker."}}); err != nil || got != "A woodpecker." {
+		t.Errorf("expected the description alone, got %q (err %v)", got, err)
+	}
+}
+
+func TestParseExtMetadataCoordinates(t *testing.T) {
+	if got := parseExtMetadataCoordinates("40.7812", "-73.9665"); got == nil || *got != (Coordinates{Latitude: 40.7812, Longitude: -73.9665}) {
+		t.Errorf("unexpected coordinates %v", got)
+	}
+	if got := parseExtMetadataCoordinates("", ""); got != nil {
+		t.Errorf("expected no coordinates, got %v", got)
+	}
+}
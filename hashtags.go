@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// wikidataApiUrl is the MediaWiki action API endpoint of Wikidata, which holds the labels of depicted items.
+var wikidataApiUrl = "https://www.wikidata.org/w/api.php"
+
+// Hashtags is a list of hashtags, each including its "#", which templates print separated by spaces.
+type Hashtags []string
+
+func (hashtags Hashtags) String() string {
+	return strings.Join(hashtags, " ")
+}
+
+// HashtagOptions control which hashtags are added to posts.
+type HashtagOptions struct {
+	// MaxCount is the most hashtags added to a post, where 0 disables hashtags altogether.
+	MaxCount int
+	// MaxWords is the most words a category or label may have to be made into a hashtag, since long ones are unreadable.
+	MaxWords int
+	// Blocklist names hashtags, without "#" and in any case, which are never added.
+	Blocklist []string
+}
+
+// defaultHashtagBlocklist leaves out categories which every potd is in.
+var defaultHashtagBlocklist = []string{"PicturesOfTheDay", "FeaturedPictures", "QualityImages", "ValuedImages"}
+
+// getJson fetches an API response and decodes it into result.
+func getJson(ctx context.Context, apiUrl string, query url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiUrl+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad http status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// getCategories returns the names of the visible categories of a Commons file, without the "Category:" prefix.
+func getCategories(ctx context.Context, fileName string) ([]string, error) {
+	var response struct {
+		Query struct {
+			Pages map[string]struct {
+				Categories []struct {
+					Title string `json:"title"`
+				} `json:"categories"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	err := getJson(ctx, commonsApiUrl, url.Values{
+		"action":  {"query"},
+		"format":  {"json"},
+		"prop":    {"categories"},
+		"clshow":  {"!hidden"},
+		"cllimit": {"max"},
+		"titles":  {"File:" + fileName},
+	}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []string
+	for _, page := range response.Query.Pages {
+		for _, category := range page.Categories {
+			categories = append(categories, strings.TrimPrefix(category.Title, "Category:"))
+		}
+	}
+	return categories, nil
+}
+
+// parenthetical matches a qualifier such as " (male)" or " (Sphyrapicus)" at the end of a category or label.
+var parenthetical = regexp.MustCompile(`\s*\([^)]*\)$`)
+
+// hashtagFromName turns a category or label into a hashtag such as "#NewYorkCity", or returns an empty string if
+// it has more than maxWords words or would make an invalid hashtag.
+func hashtagFromName(name string, maxWords int) string {
+	name = parenthetical.ReplaceAllString(name, "")
+	words := strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) })
+	if len(words) == 0 || len(words) > maxWords {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString("#")
+	for _, word := range words {
+		// capitalise each word to keep them apart, leaving the rest of it alone so that acronyms are kept
+		runes := []rune(word)
+		builder.WriteString(string(unicode.ToUpper(runes[0])) + string(runes[1:]))
+	}
+	hashtag := builder.String()
+
+	// a hashtag made up only of digits is not recognised as one
+	if !strings.ContainsFunc(hashtag, unicode.IsLetter) {
+		return ""
+	}
+	return hashtag
+}
+
+// selectHashtags makes hashtags from the depicted items, which describe the picture best, followed by the categories,
+// leaving out duplicates and blocked hashtags, up to the maximum count.
+func selectHashtags(depicts []string, categories []string, opts HashtagOptions) Hashtags {
+	seen := map[string]bool{}
+	for _, blocked := range opts.Blocklist {
+		seen[strings.ToLower(strings.TrimPrefix(blocked, "#"))] = true
+	}
+
+	var hashtags Hashtags
+	for _, name := range append(append([]string{}, depicts...), categories...) {
+		if len(hashtags) == opts.MaxCount {
+			break
+		}
+		hashtag := hashtagFromName(name, opts.MaxWords)
+		key := strings.ToLower(strings.TrimPrefix(hashtag, "#"))
+		if hashtag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		hashtags = append(hashtags, hashtag)
+	}
+	return hashtags
+}
+
+// generateHashtags returns hashtags for a Commons file which depicts the given items and is in the given categories.
+func generateHashtags(categories []string, depicts []DepictedItem, opts HashtagOptions) Hashtags {
+	if opts.MaxCount <= 0 {
+		return nil
+	}
+
+	var labels []string
+	for _, item := range depicts {
+		if item.Label != "" {
+			labels = append(labels, item.Label)
+		}
+	}
+
+	hashtags := selectHashtags(labels, categories, opts)
+	log.WithFields(log.Fields{"depicts": labels, "categories": categories, "hashtags": hashtags}).Info("generated hashtags")
+	return hashtags
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// commonsFixture serves recorded API responses for the categories and structured data of a file, and the labels of
+// the items it depicts.
+func commonsFixture(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Get("action") == "query" && query.Get("prop") == "categories":
+			w.Write([]byte(`{"batchcomplete":"","query":{"pages":{"123":{"pageid":123,"ns":6,"title":"File:Sapsucker.jpg","categories":[` +
+				`{"ns":14,"title":"Category:Pictures of the day"},` +
+				`{"ns":14,"title":"Category:Sphyrapicus varius (male)"},` +
+				`{"ns":14,"title":"Category:Central Park"},` +
+				`{"ns":14,"title":"Category:Birds photographed in Central Park in winter 2021"},` +
+				`{"ns":14,"title":"Category:2021"}]}}}}`))
+		case query.Get("action") == "wbgetentities" && query.Get("ids") == "M123":
+			w.Write([]byte(`{"entities":{"M123":{"type":"mediainfo","id":"M123",` +
+				`"labels":{"en":{"language":"en","value":"Male yellow-bellied sapsucker in Central Park"},"de":{"language":"de","value":"Männlicher Gelbbauch-Saftlecker im Central Park"}},` +
+				`"statements":{"P180":[` +
+				`{"mainsnak":{"snaktype":"value","property":"P180","datavalue":{"value":{"entity-type":"item","numeric-id":1043301,"id":"Q1043301"},"type":"wikibase-entityid"}},"type":"statement","rank":"normal"},` +
+				`{"mainsnak":{"snaktype":"value","property":"P180","datavalue":{"value":{"entity-type":"item","numeric-id":60,"id":"Q60"},"type":"wikibase-entityid"}},"type":"statement","rank":"normal"}],` +
+				`"P1259":[{"mainsnak":{"snaktype":"value","property":"P1259","datavalue":{"value":{"latitude":40.7812,"longitude":-73.9665,"altitude":null,"precision":0.0001,"globe":"http://www.wikidata.org/entity/Q2"},"type":"globecoordinate"}},"type":"statement","rank":"normal"}]}}},"success":1}`))
+		case query.Get("action") == "wbgetentities" && query.Get("ids") == "M404":
+			w.Write([]byte(`{"entities":{"M404":{"id":"M404","missing":""}},"success":1}`))
+		case query.Get("action") == "wbgetentities" && query.Get("ids") == "Q1043301|Q60":
+			w.Write([]byte(`{"entities":{` +
+				`"Q1043301":{"type":"item","id":"Q1043301","labels":{"en":{"language":"en","value":"yellow-bellied sapsucker"}}},` +
+				`"Q60":{"type":"item","id":"Q60","labels":{"en":{"language":"en","value":"New York City"}}}},"success":1}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+// useCommonsFixture points the API endpoints at the fixture server until the test ends.
+func useCommonsFixture(t *testing.T) {
+	server := commonsFixture(t)
+	t.Cleanup(server.Close)
+	commons, wikidata := commonsApiUrl, wikidataApiUrl
+	t.Cleanup(func() { commonsApiUrl, wikidataApiUrl = commons, wikidata })
+	commonsApiUrl, wikidataApiUrl = server.URL, server.URL
+}
+
+func TestGenerateHashtags(t *testing.T) {
+	useCommonsFixture(t)
+	depicts := []DepictedItem{{Id: "Q1043301", Label: "yellow-bellied sapsucker"}, {Id: "Q60", Label: "New York City"}, {Id: "Q1"}}
+
+	categories, err := getCategories(context.Background(), "Sapsucker.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := HashtagOptions{MaxCount: 10, MaxWords: 3, Blocklist: defaultHashtagBlocklist}
+	got := generateHashtags(categories, depicts, opts)
+	expected := Hashtags{"#YellowBelliedSapsucker", "#NewYorkCity", "#SphyrapicusVarius", "#CentralPark"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if got.String() != "#YellowBelliedSapsucker #NewYorkCity #SphyrapicusVarius #CentralPark" {
+		t.Errorf("unexpected printed hashtags %q", got.String())
+	}
+
+	opts.MaxCount = 2
+	opts.Blocklist = append(opts.Blocklist, "#newyorkcity")
+	got = generateHashtags(categories, depicts, opts)
+	expected = Hashtags{"#YellowBelliedSapsucker", "#SphyrapicusVarius"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v with blocklist and maximum count, got %v", expected, got)
+	}
+}
+
+func TestHashtagFromName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Central Park":                   "#CentralPark",
+		"Sphyrapicus varius (male)":      "#SphyrapicusVarius",
+		"USA":                            "#USA",
+		"São Paulo":                      "#SãoPaulo",
+		"2021":                           "",
+		"Birds photographed in New York": "",
+		"yellow-bellied sapsucker":       "#YellowBelliedSapsucker",
+		"Musée d'Orsay":                  "#MuséeDOrsay",
+	} {
+		if got := hashtagFromName(name, 3); got != expected {
+			t.Errorf("expected %q for %q, got %q", expected, name, got)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dateLayout formats the Commons day of a potd, which begins at midnight UTC.
+const dateLayout = "2006-01-02"
+
+// HistoryEntry records what an account has posted for the potd of one day. Entries are written after every post,
+// so an entry which is not Complete is a checkpoint from which an interrupted thread is resumed.
+type HistoryEntry struct {
+	Date     string `json:"date"`
+	Account  string `json:"account"`
+	FileName string `json:"fileName"`
+	// PostIds are the ids of the posts made so far, in the order of the thread.
+	PostIds []string `json:"postIds,omitempty"`
+	// Skipped is the reason the potd was not posted, if the filter of the account rejected it.
+	Skipped  string    `json:"skipped,omitempty"`
+	Complete bool      `json:"complete"`
+	Time     time.Time `json:"time"`
+}
+
+// History is an append-only file of HistoryEntry values, one JSON object per line, in which the latest entry for
+// a day and account replaces any earlier ones. A nil History, or one without a path, records nothing.
+type History struct {
+	Path string
+}
+
+// defaultStateFile returns the path of a per-user state file, such as the history, or an empty string (disabling it)
+// if there is no home directory.
+func defaultStateFile(name string) string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "wikicommonspotd", name)
+}
+
+// Enabled reports whether the history is in use.
+func (h *History) Enabled() bool {
+	return h != nil && h.Path != ""
+}
+
+// Entries returns the latest entry for each day and account, in the order they were first recorded.
+// A line which cannot be decoded, such as one cut off by a crash, is skipped.
+func (h *History) Entries() ([]HistoryEntry, error) {
+	if !h.Enabled() {
+		return nil, nil
+	}
+	file, err := os.Open(h.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	index := map[[2]string]int{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.WithError(err).WithFields(log.Fields{"path": h.Path, "line": line}).Warn("skipping unreadable history entry")
+			continue
+		}
+		key := [2]string{entry.Date, entry.Account}
+		if i, ok := index[key]; ok {
+			entries[i] = entry
+		} else {
+			index[key] = len(entries)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Get returns the latest entry for the account on the given day, if there is one.
+func (h *History) Get(date time.Time, account string) (HistoryEntry, bool, error) {
+	entries, err := h.Entries()
+	if err != nil {
+		return HistoryEntry{}, false, err
+	}
+	for _, entry := range entries {
+		if entry.Date == date.Format(dateLayout) && entry.Account == account {
+			return entry, true, nil
+		}
+	}
+	return HistoryEntry{}, false, nil
+}
+
+// Done reports whether every one of the accounts has completed the potd of the given day.
+func (h *History) Done(date time.Time, accounts []AccountConfig) (bool, error) {
+	for _, account := range accounts {
+		entry, ok, err := h.Get(date, account.Name)
+		if err != nil || !ok || !entry.Complete {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Record appends the entry, and waits until it is on disk so that a crash immediately afterwards does not lose it.
+func (h *History) Record(entry HistoryEntry) error {
+	if !h.Enabled() {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	entry.Time = time.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
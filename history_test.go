@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Test that the latest entry for a day and account replaces earlier ones, and that a cut off line is skipped.
+func TestHistory(t *testing.T) {
+	history := &History{Path: filepath.Join(t.TempDir(), "state", "history.jsonl")}
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	accounts := []AccountConfig{{Name: "english"}, {Name: "german"}}
+
+	if entries, err := history.Entries(); err != nil || len(entries) != 0 {
+		t.Fatalf("expected a missing file to be an empty history, got %v (err %v)", entries, err)
+	}
+
+	checkpoint := HistoryEntry{Date: "2024-03-01", Account: "english", FileName: "Sapsucker.jpg", PostIds: []string{"1"}}
+	history.Record(checkpoint)
+	history.Record(HistoryEntry{Date: "2024-03-01", Account: "german", FileName: "Sapsucker.jpg", Skipped: "the picture is landscape", Complete: true})
+	if done, err := history.Done(date, accounts); err != nil || done {
+		t.Errorf("expected day with an interrupted thread not to be done (err %v)", err)
+	}
+
+	checkpoint.PostIds = append(checkpoint.PostIds, "2")
+	checkpoint.Complete = true
+	history.Record(checkpoint)
+	file, _ := os.OpenFile(history.Path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"date":"2024-03-02","acc`)
+	file.Close()
+
+	entry, ok, err := history.Get(date, "english")
+	if err != nil || !ok || !reflect.DeepEqual(entry.PostIds, []string{"1", "2"}) || !entry.Complete || entry.Time.IsZero() {
+		t.Errorf("expected latest entry, got %+v (ok %v, err %v)", entry, ok, err)
+	}
+	if done, err := history.Done(date, accounts); err != nil || !done {
+		t.Errorf("expected day to be done (err %v)", err)
+	}
+	if done, _ := history.Done(date.AddDate(0, 0, 1), accounts); done {
+		t.Error("expected next day not to be done")
+	}
+	if entries, _ := history.Entries(); len(entries) != 2 {
+		t.Errorf("expected one entry for each account, got %+v", entries)
+	}
+}
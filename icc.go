@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+// IccProfile is a parsed RGB matrix/TRC colour profile, the kind embedded by cameras and editors for
+// sRGB, Adobe RGB, Display P3 and ProPhoto RGB. Profiles based on lookup tables are not supported.
+type IccProfile struct {
+	// Colorants holds the D50 XYZ values of the red, green and blue primaries.
+	Colorants [3][3]float64
+	// Curves holds the tone reproduction curve of each channel.
+	Curves [3]toneCurve
+}
+
+// toneCurve converts an encoded channel value in [0, 1] to a linear one.
+type toneCurve func(float64) float64
+
+// the primaries of sRGB adapted to D50, as they appear in the colorant tags of an sRGB profile
+var srgbColorants = [3][3]float64{
+	{0.4360747, 0.2225045, 0.0139322},
+	{0.3850649, 0.7168786, 0.0971045},
+	{0.1430804, 0.0606169, 0.7141733},
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseIccProfile reads the colorant and tone curve tags of an RGB profile.
+func parseIccProfile(buf []byte) (*IccProfile, error) {
+	if len(buf) < 132 || string(buf[36:40]) != "acsp" {
+		return nil, errors.New("not an ICC profile")
+	}
+	if string(buf[16:20]) != "RGB " {
+		return nil, errors.New("colour space of ICC profile is not RGB")
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(buf[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + 12*i
+		if entry+12 > len(buf) {
+			return nil, errors.New("ICC tag table out of range")
+		}
+		offset := int(binary.BigEndian.Uint32(buf[entry+4:]))
+		size := int(binary.BigEndian.Uint32(buf[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(buf) {
+			return nil, errors.New("ICC tag out of range")
+		}
+		tags[string(buf[entry:entry+4])] = buf[offset : offset+size]
+	}
+
+	profile := &IccProfile{}
+	for i, channel := range []string{"r", "g", "b"} {
+		xyz, ok := tags[channel+"XYZ"]
+		if !ok || len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, errors.New("ICC profile has no colorant matrix")
+		}
+		for j := 0; j < 3; j++ {
+			profile.Colorants[i][j] = s15Fixed16(xyz[8+4*j:])
+		}
+
+		trc, ok := tags[channel+"TRC"]
+		if !ok {
+			return nil, errors.New("ICC profile has no tone reproduction curves")
+		}
+		curve, err := parseToneCurve(trc)
+		if err != nil {
+			return nil, err
+		}
+		profile.Curves[i] = curve
+	}
+	return profile, nil
+}
+
+func parseToneCurve(tag []byte) (toneCurve, error) {
+	if len(tag) < 12 {
+		return nil, errors.New("ICC tone curve too short")
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:]))
+		if count == 0 {
+			return func(v float64) float64 { return v }, nil
+		}
+		if count == 1 {
+			if len(tag) < 14 {
+				return nil, errors.New("ICC tone curve gamma out of range")
+			}
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		if len(tag) < 12+2*count {
+			return nil, errors.New("ICC tone curve table out of range")
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		// interpolate linearly between table entries
+		return func(v float64) float64 {
+			position := v * float64(count-1)
+			i := int(position)
+			if i >= count-1 {
+				return table[count-1]
+			}
+			fraction := position - float64(i)
+			return table[i]*(1-fraction) + table[i+1]*fraction
+		}, nil
+	case "para":
+		// parametric curves of function types 0 to 4, see section 10.18 of the ICC specification
+		functionType := binary.BigEndian.Uint16(tag[8:])
+		paramCounts := []int{1, 3, 4, 5, 7}
+		if int(functionType) >= len(paramCounts) || len(tag) < 12+4*paramCounts[functionType] {
+			return nil, errors.New("unsupported ICC parametric curve")
+		}
+		p := make([]float64, 7)
+		for i := 0; i < paramCounts[functionType]; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		return func(v float64) float64 {
+			switch functionType {
+			case 0:
+				return math.Pow(v, g)
+			case 1:
+				if v >= -b/a {
+					return math.Pow(a*v+b, g)
+				}
+				return 0
+			case 2:
+				if v >= -b/a {
+					return math.Pow(a*v+b, g) + c
+				}
+				return c
+			case 3:
+				if v >= d {
+					return math.Pow(a*v+b, g)
+				}
+				return c * v
+			default:
+				if v >= d {
+					return math.Pow(a*v+b, g) + e
+				}
+				return c*v + f
+			}
+		}, nil
+	}
+	return nil, errors.New("unsupported ICC tone curve type")
+}
+
+// IsSRGB reports whether the profile has the sRGB primaries and tone curves, in which case no conversion is necessary.
+func (profile *IccProfile) IsSRGB() bool {
+	for i := range srgbColorants {
+		for j := range srgbColorants[i] {
+			if math.Abs(profile.Colorants[i][j]-srgbColorants[i][j]) > 0.005 {
+				return false
+			}
+		}
+	}
+	// a curve matches if every 8-bit value comes out within one level once encoded as sRGB again, which tells
+	// tables and parametric curves of sRGB apart from plain gamma curves such as 2.2
+	for _, curve := range profile.Curves {
+		for i := 0; i <= 255; i++ {
+			v := float64(i) / 255
+			if curve == nil || math.Abs(srgbEncode(curve(v))-v) > 1.0/255 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// invert3 inverts a 3x3 matrix.
+func invert3(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	var inverse [3][3]float64
+	inverse[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	inverse[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	inverse[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	inverse[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	inverse[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	inverse[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	inverse[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	inverse[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	inverse[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+	return inverse
+}
+
+// columns arranges per-primary colorants as the columns of an RGB to XYZ matrix.
+func columns(colorants [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[j][i] = colorants[i][j]
+		}
+	}
+	return m
+}
+
+func multiply3(a [3][3]float64, b [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+
+// srgbEncode applies the sRGB transfer function to a linear value.
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// ConvertToSRGB returns a copy of the image with its colours converted from the profile's colour space to sRGB.
+// Out of gamut colours are clipped.
+func (profile *IccProfile) ConvertToSRGB(src image.Image) *image.NRGBA {
+	// linear source RGB to XYZ, then XYZ to linear sRGB
+	transform := multiply3(invert3(columns(srgbColorants)), columns(profile.Colorants))
+
+	// tabulate the curves, since there are only 256 input values per channel
+	var linearise [3][256]float64
+	for channel := 0; channel < 3; channel++ {
+		for v := 0; v < 256; v++ {
+			linearise[channel][v] = profile.Curves[channel](float64(v) / 255)
+		}
+	}
+	const encodeSteps = 4096
+	var encode [encodeSteps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(255 * srgbEncode(float64(i)/encodeSteps)))
+	}
+
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := color.NRGBAModel.Convert(src.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			in := [3]float64{linearise[0][c.R], linearise[1][c.G], linearise[2][c.B]}
+			var out [3]uint8
+			for i := 0; i < 3; i++ {
+				v := transform[i][0]*in[0] + transform[i][1]*in[1] + transform[i][2]*in[2]
+				v = math.Max(0, math.Min(1, v))
+				out[i] = encode[int(math.Round(v*encodeSteps))]
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{R: out[0], G: out[1], B: out[2], A: c.A})
+		}
+	}
+	return dst
+}
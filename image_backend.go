@@ -0,0 +1,40 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// ImageSize holds the pixel dimensions of a decoded image.
+type ImageSize struct {
+	Width  int
+	Height int
+}
+
+// EncodeOptions control the output of ImageBackend.Resize.
+type EncodeOptions struct {
+	// Format is the output format, either FormatJPEG or FormatPNG.
+	Format string
+	// Quality is the JPEG quality, and is ignored for PNG output.
+	Quality int
+	// Background is the colour onto which any transparency is flattened.
+	Background color.RGBA
+}
+
+// ImageBackend is the set of image operations needed by compressFile.
+// The implementation is chosen at build time: the default build uses bimg (and therefore libvips),
+// while building with the purego tag selects an implementation using only the Go standard library and x/image.
+// Both implementations decode only the first page or frame of multi-page TIFF and animated GIF files.
+type ImageBackend interface {
+	// Name identifies the backend in log output.
+	Name() string
+	// Size reads the dimensions of an encoded image as stored, disregarding any EXIF orientation.
+	Size(buf []byte) (ImageSize, error)
+	// Resize scales an encoded image to the given width, preserving its aspect ratio,
+	// flattens it onto the background colour and re-encodes it in the requested format.
+	// The image is rotated upright according to its EXIF orientation, the width referring to the upright image,
+	// and converted to sRGB using its embedded colour profile. The output carries no metadata.
+	Resize(buf []byte, width int, opts EncodeOptions) ([]byte, error)
+	// Crop extracts a region of the upright image at full resolution, with the same processing as Resize.
+	Crop(buf []byte, region image.Rectangle, opts EncodeOptions) ([]byte, error)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Generate a PNG of random noise, which compresses poorly and is therefore a worst case for compressFile.
+func noisePng(t *testing.T, width int, height int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("could not encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Test that the backend reports dimensions and resizes while keeping the aspect ratio.
+func TestImageBackendResize(t *testing.T) {
+	backend := newImageBackend()
+	original := noisePng(t, 400, 200)
+
+	size, err := backend.Size(original)
+	if err != nil || size.Width != 400 || size.Height != 200 {
+		t.Fatalf("%s: expected 400x200, got %dx%d (err %v)", backend.Name(), size.Width, size.Height, err)
+	}
+
+	resized, err := backend.Resize(original, 100, EncodeOptions{Format: FormatJPEG, Quality: 90})
+	if err != nil {
+		t.Fatalf("%s: resize failed: %v", backend.Name(), err)
+	}
+	size, err = backend.Size(resized)
+	if err != nil || size.Width != 100 || size.Height != 50 {
+		t.Errorf("%s: expected 100x50, got %dx%d (err %v)", backend.Name(), size.Width, size.Height, err)
+	}
+	if format := detectFormat(resized); format != FormatJPEG {
+		t.Errorf("%s: expected jpeg output, got %s", backend.Name(), format)
+	}
+}
+
+// Test that transparent regions are flattened onto the background colour.
+func TestImageBackendFlatten(t *testing.T) {
+	backend := newImageBackend()
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	background := color.RGBA{R: 255, G: 0, B: 0, A: 255}
+	flattened, err := backend.Resize(buf.Bytes(), 32, EncodeOptions{Format: FormatPNG, Background: background})
+	if err != nil {
+		t.Fatalf("%s: resize failed: %v", backend.Name(), err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(flattened))
+	if err != nil {
+		t.Fatalf("%s: expected png output: %v", backend.Name(), err)
+	}
+	r, g, b, a := decoded.At(16, 16).RGBA()
+	if r>>8 != 255 || g>>8 != 0 || b>>8 != 0 || a>>8 != 255 {
+		t.Errorf("%s: expected opaque red, got %d %d %d %d", backend.Name(), r>>8, g>>8, b>>8, a>>8)
+	}
+}
+
+// Test that compressFile brings a large image below the size limit, and leaves a small JPEG untouched.
+func TestCompressFile(t *testing.T) {
+	dir := t.TempDir()
+
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	path := filepath.Join(dir, "original.png")
+	if err := os.WriteFile(path, noisePng(t, 600, 400), 0644); err != nil {
+		t.Fatal(err)
+	}
+	small, err := newImageBackend().Resize(noisePng(t, 60, 40), 60, EncodeOptions{Format: FormatJPEG, Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallPath := filepath.Join(dir, "small.jpeg")
+	if err := os.WriteFile(smallPath, small, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := compressFile(context.Background(), smallPath, CompressOptions{Quality: 90, FileSizeLimit: 10000000, MaxDimension: 4096, Background: white}); got != smallPath {
+		t.Errorf("expected small file to be returned as-is, got %s", got)
+	}
+
+	const limit = 50000
+	got := compressFile(context.Background(), path, CompressOptions{Quality: 90, FileSizeLimit: limit, MaxDimension: 4096, Background: white})
+	defer os.Remove(got)
+	info, err := os.Stat(got)
+	if err != nil {
+		t.Fatalf("could not stat compressed file: %v", err)
+	}
+	if info.Size() >= limit {
+		t.Errorf("expected compressed file below %d bytes, got %d", limit, info.Size())
+	}
+	if filepath.Ext(got) != ".jpeg" {
+		t.Errorf("expected photograph to be encoded as jpeg, got %s", got)
+	}
+}
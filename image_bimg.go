@@ -0,0 +1,69 @@
+//go:build !purego
+
+package main
+
+import (
+	"image"
+
+	"github.com/h2non/bimg"
+)
+
+type bimgBackend struct{}
+
+func newImageBackend() ImageBackend {
+	return bimgBackend{}
+}
+
+func (bimgBackend) Name() string {
+	return "bimg"
+}
+
+func (bimgBackend) Size(buf []byte) (ImageSize, error) {
+	size, err := bimg.NewImage(buf).Size()
+	if err != nil {
+		return ImageSize{}, err
+	}
+	return ImageSize{Width: size.Width, Height: size.Height}, nil
+}
+
+// bimgOptions returns the processing options shared by every operation.
+func bimgOptions(opts EncodeOptions) bimg.Options {
+	imageType := bimg.JPEG
+	if opts.Format == FormatPNG {
+		imageType = bimg.PNG
+	}
+	// libvips rotates according to the EXIF orientation by default, and "srgb" names its built-in sRGB profile,
+	// which is converted to from the embedded profile before all metadata is stripped
+	return bimg.Options{
+		Quality:       opts.Quality,
+		Type:          imageType,
+		Background:    bimg.Color{R: opts.Background.R, G: opts.Background.G, B: opts.Background.B},
+		OutputICC:     "srgb",
+		StripMetadata: true,
+	}
+}
+
+func (bimgBackend) Resize(buf []byte, width int, opts EncodeOptions) ([]byte, error) {
+	options := bimgOptions(opts)
+	options.Width = width
+	return bimg.NewImage(buf).Process(options)
+}
+
+func (bimgBackend) Crop(buf []byte, region image.Rectangle, opts EncodeOptions) ([]byte, error) {
+	// extraction happens before rotation, so rotate upright first, which keeps the colour profile
+	upright, err := bimg.NewImage(buf).AutoRotate()
+	if err != nil {
+		return nil, err
+	}
+
+	options := bimgOptions(opts)
+	options.Top = region.Min.Y
+	options.Left = region.Min.X
+	options.AreaWidth = region.Dx()
+	options.AreaHeight = region.Dy()
+	// as in bimg's own Extract, a zero offset would otherwise not be recognised as an extraction
+	if options.Top == 0 && options.Left == 0 {
+		options.Top = -1
+	}
+	return bimg.NewImage(upright).Process(options)
+}
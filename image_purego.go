@@ -0,0 +1,113 @@
+//go:build purego
+
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	// register the decoders for every format that may appear as a potd
+	_ "image/gif"
+
+	log "github.com/sirupsen/logrus"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+type pureGoBackend struct{}
+
+func newImageBackend() ImageBackend {
+	return pureGoBackend{}
+}
+
+func (pureGoBackend) Name() string {
+	return "purego"
+}
+
+func (pureGoBackend) Size(buf []byte) (ImageSize, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return ImageSize{}, err
+	}
+	return ImageSize{Width: config.Width, Height: config.Height}, nil
+}
+
+func (pureGoBackend) Resize(buf []byte, width int, opts EncodeOptions) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	metadata := readMetadata(buf)
+
+	// keep the aspect ratio of the upright image, rounding the height to the nearest pixel
+	bounds := src.Bounds()
+	uprightWidth, uprightHeight := bounds.Dx(), bounds.Dy()
+	if swapsAxes(metadata.Orientation) {
+		uprightWidth, uprightHeight = uprightHeight, uprightWidth
+	}
+	height := (uprightHeight*width + uprightWidth/2) / uprightWidth
+	if height < 1 {
+		height = 1
+	}
+
+	// scale before rotating, so that only the smaller image has to be transformed
+	scaledWidth, scaledHeight := width, height
+	if swapsAxes(metadata.Orientation) {
+		scaledWidth, scaledHeight = height, width
+	}
+
+	// fill the destination with the background colour, so that transparent regions are flattened onto it while scaling
+	background := opts.Background
+	background.A = 255
+	scaled := image.NewRGBA(image.Rect(0, 0, scaledWidth, scaledHeight))
+	draw.Draw(scaled, scaled.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), src, bounds, draw.Over, nil)
+
+	return encodeUpright(applyOrientation(scaled, metadata.Orientation), metadata, opts)
+}
+
+func (pureGoBackend) Crop(buf []byte, region image.Rectangle, opts EncodeOptions) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	metadata := readMetadata(buf)
+	upright := applyOrientation(src, metadata.Orientation)
+
+	background := opts.Background
+	background.A = 255
+	cropped := image.NewRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(cropped, cropped.Bounds(), upright, upright.Bounds().Min.Add(region.Min), draw.Over)
+
+	return encodeUpright(cropped, metadata, opts)
+}
+
+// encodeUpright converts an image which has already been rotated upright to sRGB, and encodes it in the requested format.
+func encodeUpright(dst image.Image, metadata ImageMetadata, opts EncodeOptions) ([]byte, error) {
+	if metadata.IccProfile != nil {
+		profile, err := parseIccProfile(metadata.IccProfile)
+		if err != nil {
+			log.WithError(err).Warn("could not parse embedded colour profile, assuming sRGB")
+		} else if !profile.IsSRGB() {
+			dst = profile.ConvertToSRGB(dst)
+		}
+	}
+
+	// the encoders write no metadata, so the output is stripped of it
+	var err error
+	out := &bytes.Buffer{}
+	if opts.Format == FormatPNG {
+		err = png.Encode(out, dst)
+	} else {
+		err = jpeg.Encode(out, dst, &jpeg.Options{Quality: opts.Quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
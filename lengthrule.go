@@ -0,0 +1,118 @@
+package main
+
+import (
+	"regexp"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	twtextparse "github.com/myl7/twitter-text-parse-go/pkg/gnu"
+	"github.com/rivo/uniseg"
+	log "github.com/sirupsen/logrus"
+)
+
+// LengthRule measures text the way a social network does when enforcing its limit on the length of a post.
+// The splitter assumes that lengths are additive: the length of words joined by spaces is the sum of the lengths of
+// the words and the spaces.
+type LengthRule interface {
+	// Name identifies the network in log output.
+	Name() string
+	// Length measures text as the network counts it towards its limit.
+	Length(text string) int
+	// Limit is the greatest length of a single post.
+	Limit() int
+}
+
+// TwitterRule uses twitter-text's weighted length, which counts most non-Latin characters and emoji twice,
+// and URLs as 23.
+var TwitterRule LengthRule = twitterRule{}
+
+// MastodonRule counts characters, except that URLs count as 23 regardless of their length, up to the default limit of 500.
+var MastodonRule LengthRule = mastodonRule{}
+
+// BlueskyRule counts grapheme clusters, up to 300.
+var BlueskyRule LengthRule = blueskyRule{}
+
+// TelegramCaptionRule counts UTF-16 code units, up to the 1024 permitted in the caption of a photo.
+var TelegramCaptionRule LengthRule = telegramCaptionRule{}
+
+// lengthRules maps network names to their rules.
+var lengthRules = map[string]LengthRule{
+	"twitter":  TwitterRule,
+	"mastodon": MastodonRule,
+	"bluesky":  BlueskyRule,
+	"telegram": TelegramCaptionRule,
+}
+
+type twitterRule struct{}
+
+func (twitterRule) Name() string {
+	return "twitter"
+}
+
+func (twitterRule) Length(text string) int {
+	res, err := twtextparse.Parse(text)
+	if err != nil {
+		log.WithField("text", text).Panic("could not parse text to determine weighted length")
+	}
+	return res.WeightedLength
+}
+
+func (twitterRule) Limit() int {
+	return 280
+}
+
+type mastodonRule struct{}
+
+// mastodonUrl matches the links which Mastodon shortens for counting purposes.
+var mastodonUrl = regexp.MustCompile(`https?://\S+`)
+
+// mastodonUrlLength is the length Mastodon counts for every link.
+const mastodonUrlLength = 23
+
+func (mastodonRule) Name() string {
+	return "mastodon"
+}
+
+func (mastodonRule) Length(text string) int {
+	length := utf8.RuneCountInString(text)
+	for _, url := range mastodonUrl.FindAllString(text, -1) {
+		length += mastodonUrlLength - utf8.RuneCountInString(url)
+	}
+	return length
+}
+
+func (mastodonRule) Limit() int {
+	return 500
+}
+
+type blueskyRule struct{}
+
+func (blueskyRule) Name() string {
+	return "bluesky"
+}
+
+func (blueskyRule) Length(text string) int {
+	return uniseg.GraphemeClusterCount(text)
+}
+
+func (blueskyRule) Limit() int {
+	return 300
+}
+
+type telegramCaptionRule struct{}
+
+func (telegramCaptionRule) Name() string {
+	return "telegram"
+}
+
+func (telegramCaptionRule) Length(text string) int {
+	length := 0
+	for _, r := range text {
+		length += utf16.RuneLen(r)
+	}
+	return length
+}
+
+func (telegramCaptionRule) Limit() int {
+	return 1024
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestLengthRules(t *testing.T) {
+	cases := []struct {
+		rule     LengthRule
+		text     string
+		expected int
+	}{
+		{TwitterRule, "Њ👾", 3},
+		{TwitterRule, "see https://commons.wikimedia.org/wiki/Main_Page", 27},
+		{MastodonRule, "Њ👾", 2},
+		{MastodonRule, "see https://commons.wikimedia.org/wiki/Main_Page", 27},
+		{MastodonRule, "👨‍👩‍👧‍👦", 7},
+		{BlueskyRule, "👨‍👩‍👧‍👦 🙋🏽", 3},
+		{BlueskyRule, "é", 1},
+		{TelegramCaptionRule, "Њ👾", 3},
+		{TelegramCaptionRule, "👨‍👩‍👧‍👦", 11},
+	}
+	for _, c := range cases {
+		if got := c.rule.Length(c.text); got != c.expected {
+			t.Errorf("%s: expected length %d for %q, got %d", c.rule.Name(), c.expected, c.text, got)
+		}
+	}
+}
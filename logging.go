@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// setupLogging applies the logging settings to the standard logger. Logs are written to standard error unless a
+// directory is configured, in which case the returned function closes the log file.
+func setupLogging(config LogConfig) (func() error, error) {
+	level, err := log.ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+	log.SetLevel(level)
+	log.SetFormatter(logFormatter(config.Format))
+
+	if config.Dir == "" {
+		log.SetOutput(os.Stderr)
+		return func() error { return nil }, nil
+	}
+	file := &rotatingFile{dir: config.Dir, maxBytes: config.MaxBytes, maxFiles: config.MaxFiles, now: time.Now}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	log.SetOutput(file)
+	return file.Close, nil
+}
+
+// logFormatter returns the formatter for a log format, which is json unless it is text.
+func logFormatter(format string) log.Formatter {
+	if format == "text" {
+		return &log.TextFormatter{FullTimestamp: true}
+	}
+	return &log.JSONFormatter{}
+}
+
+// runIdHook adds the id of the current run to every log entry, so that the entries of one run can be picked out
+// of a log shared with earlier runs, and found from an alert.
+type runIdHook struct {
+	mutex sync.Mutex
+	id    string
+}
+
+// runId identifies the current run. The daemon starts a new run each time it posts.
+var runId = &runIdHook{}
+
+func init() {
+	runId.start()
+	log.AddHook(runId)
+	log.AddHook(redactHook{})
+}
+
+// start gives the run which is starting a new random id, and returns it.
+func (h *runIdHook) start() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.id = hex.EncodeToString(buf)
+	return h.id
+}
+
+// get returns the id of the current run.
+func (h *runIdHook) get() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.id
+}
+
+func (h *runIdHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *runIdHook) Fire(entry *log.Entry) error {
+	entry.Data["runId"] = h.get()
+	return nil
+}
+
+// credentialPatterns match the credentials sent in the Authorization header of OAuth 1.0a and bearer token requests,
+// and the OAuth parameters which can also be sent in a query string or form, keeping only the part before them.
+var credentialPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(OAuth )(?:oauth_\w+="[^"]*"(?:,\s*)?)+`),
+	regexp.MustCompile(`(Bearer )[\w\-.~+/]+=*`),
+	regexp.MustCompile(`(oauth_(?:consumer_key|token|signature|nonce)=)[^&\s"]+`),
+}
+
+// redact replaces any credentials in s.
+func redact(s string) string {
+	for _, pattern := range credentialPatterns {
+		s = pattern.ReplaceAllString(s, "${1}[redacted]")
+	}
+	return s
+}
+
+// redactedError hides the credentials in the message of an error, while leaving it available to errors.Is and
+// errors.As.
+type redactedError struct {
+	err error
+}
+
+func (e redactedError) Error() string {
+	return redact(e.err.Error())
+}
+
+func (e redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactHook removes credentials from log entries before they are written, such as those in the headers of a
+// request to Twitter, or in an error which quotes one.
+type redactHook struct{}
+
+func (redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (redactHook) Fire(entry *log.Entry) error {
+	entry.Message = redact(entry.Message)
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			entry.Data[key] = redact(value)
+		case error:
+			if message := value.Error(); redact(message) != message {
+				entry.Data[key] = redactedError{value}
+			}
+		case http.Header:
+			entry.Data[key] = redactHeader(value)
+		case *http.Request:
+			if value != nil {
+				entry.Data[key] = fmt.Sprintf("%s %s %v", value.Method, redact(value.URL.String()), redactHeader(value.Header))
+			}
+		}
+	}
+	return nil
+}
+
+// redactHeader returns a copy of the header without the values of the headers which carry credentials.
+func redactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"} {
+		if _, ok := header[name]; ok {
+			header.Set(name, "[redacted]")
+		}
+	}
+	return header
+}
+
+// logFileName is the name of the log file being written in the log directory. Rotated files are named after it
+// with the time they were rotated, so that they sort in the order they were written.
+const logFileName = "wikicommonspotd.log"
+
+// rotatingFile writes logs to a file in a directory, which is rotated once it would grow beyond maxBytes. Only the
+// newest maxFiles rotated files are kept.
+type rotatingFile struct {
+	mutex    sync.Mutex
+	dir      string
+	maxBytes int64
+	maxFiles int
+	now      func() time.Time
+	file     *os.File
+	size     int64
+}
+
+var _ io.WriteCloser = (*rotatingFile)(nil)
+
+// open opens the log file for appending, creating the directory if needed.
+func (f *rotatingFile) open() error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(f.dir, logFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, stat.Size()
+	return nil
+}
+
+// Write writes p, which is a whole log entry, rotating the file first if p would not fit in it.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			// keep writing to the full file rather than lose the entry
+			fmt.Fprintf(os.Stderr, "could not rotate log file: %v\n", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file after the time, opens a new one, and removes the oldest rotated files.
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	current := filepath.Join(f.dir, logFileName)
+	ext := filepath.Ext(logFileName)
+	rotated := filepath.Join(f.dir, strings.TrimSuffix(logFileName, ext)+"-"+f.now().UTC().Format("20060102T150405.000000000")+ext)
+	renameErr := os.Rename(current, rotated)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	old, err := filepath.Glob(filepath.Join(f.dir, strings.TrimSuffix(logFileName, ext)+"-*"+ext))
+	if err != nil {
+		return err
+	}
+	sort.Strings(old)
+	for len(old) > f.maxFiles {
+		if err := os.Remove(old[0]); err != nil {
+			return err
+		}
+		old = old[1:]
+	}
+	return nil
+}
+
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
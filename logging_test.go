@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRedactHook(t *testing.T) {
+	var output bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&output)
+	logger.SetFormatter(&log.JSONFormatter{})
+	logger.AddHook(redactHook{})
+
+	authorization := `OAuth oauth_consumer_key="key", oauth_nonce="nonce", oauth_signature="signature", oauth_token="token"`
+	header := http.Header{"Authorization": {authorization}, "Content-Type": {"application/json"}}
+	err := fmt.Errorf("request with %s failed: %w", authorization, context.DeadlineExceeded)
+	logger.WithError(err).WithFields(log.Fields{"header": header, "url": "https://example.org/?oauth_token=token&q=1"}).Error("request failed")
+
+	for _, secret := range []string{"key", "nonce", "signature", `"token"`, "=token"} {
+		if strings.Contains(output.String(), secret) {
+			t.Errorf("expected %s to be redacted, got %s", secret, output.String())
+		}
+	}
+	if !strings.Contains(output.String(), "application/json") || !strings.Contains(output.String(), "q=1") {
+		t.Errorf("expected fields other than credentials to be kept, got %s", output.String())
+	}
+	if header.Get("Authorization") != authorization {
+		t.Error("expected the logged header to be left unchanged")
+	}
+}
+
+func TestRedactedErrorUnwraps(t *testing.T) {
+	err := redactedError{fmt.Errorf("Bearer abc.def: %w", context.Canceled)}
+	if err.Error() != "Bearer [redacted]: context canceled" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Error("expected the redacted error to wrap the original")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	file := &rotatingFile{dir: dir, maxBytes: 10, maxFiles: 2, now: func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}}
+	if err := file.open(); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := os.ReadFile(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current) != "fourth\n" {
+		t.Errorf("expected only the last entry in the current file, got %q", current)
+	}
+	rotated, err := filepath.Glob(filepath.Join(dir, "wikicommonspotd-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("expected the oldest rotated file to be removed, got %v", rotated)
+	}
+	if oldest, _ := os.ReadFile(rotated[0]); string(oldest) != "second\n" {
+		t.Errorf("expected the second entry in the oldest file kept, got %q", oldest)
+	}
+}
+
+// Test that errors recovered from log.Panic, which are sent in alerts, do not carry credentials.
+func TestPanicErrorRedacted(t *testing.T) {
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		err := fmt.Errorf("request with Bearer secret failed: %w", context.DeadlineExceeded)
+		log.WithError(err).Panic("could not post with oauth_token=secret")
+	}()
+	err := panicError(recovered)
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected credentials to be redacted, got %q", err.Error())
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || errorKind(recovered) != "timeout" {
+		t.Errorf("expected the redacted error to wrap the original, got %q", errorKind(recovered))
+	}
+}
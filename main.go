@@ -2,29 +2,68 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"image/color"
 	"io"
 	"math"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dghubble/oauth1"
-	"github.com/h2non/bimg"
-	twtextparse "github.com/myl7/twitter-text-parse-go/pkg/gnu"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/html"
 )
 
 type PotdEntry struct {
-	Description string
-	DownloadUrl string
+	// Description is the plain text of RichDescription, for networks without formatting.
+	Description     string
+	RichDescription RichText
+	DownloadUrl     string
+	FileName        string
+	// FilePageUrl is the file description page on Commons, which shows the full resolution file and its licence.
+	FilePageUrl  string
+	ThumbnailUrl string
+	// Captions, Depicts and Coordinates come from the structured data of the file, and are filled in by addMediaInfo.
+	Captions    map[string]string
+	Depicts     []DepictedItem
+	Coordinates *Coordinates
+	// MediaInfoErr is why the structured data could not be fetched, in which case accounts which filter on the
+	// depicted items cannot tell whether the potd passes.
+	MediaInfoErr error `json:"-"`
+}
+
+// addMediaInfo fills in the fields of the entry which come from the structured data of the file on Commons.
+// They are not essential to a post, so a failure to fetch them is only logged and kept in MediaInfoErr.
+func (potd *PotdEntry) addMediaInfo(ctx context.Context, pageId int64, language string) {
+	if pageId == 0 {
+		log.WithField("fileName", potd.FileName).Warn("page id of file is unknown, skipping structured data")
+		return
+	}
+	info, err := getMediaInfo(ctx, mediaInfoId(pageId), language)
+	if err != nil {
+		log.WithError(err).WithField("fileName", potd.FileName).Warn("could not fetch structured data of file")
+		potd.MediaInfoErr = err
+		return
+	}
+	potd.Captions, potd.Depicts, potd.Coordinates = info.Captions, info.Depicts, info.Coordinates
+	log.WithFields(log.Fields{"captions": info.Captions, "depicts": info.Depicts, "coordinates": info.Coordinates}).Info("fetched structured data of file")
+}
+
+// Caption returns the caption of the file in the given language, falling back to English, or an empty string.
+func (potd PotdEntry) Caption(language string) string {
+	if caption, ok := potd.Captions[language]; ok {
+		return caption
+	}
+	return potd.Captions["en"]
 }
 
 type MediaUpload struct {
@@ -67,16 +106,6 @@ func depthFirstTraverse(node *html.Node, visit func(*html.Node)) {
 	f(node)
 }
 
-func textDescription(node *html.Node) string {
-	result := ""
-	depthFirstTraverse(node, func(n *html.Node) {
-		if n.Type == html.TextNode {
-			result += n.Data
-		}
-	})
-	return result
-}
-
 func getPotdFromXML(htmlTable string) PotdEntry {
 	doc, err := html.Parse(strings.NewReader(htmlTable))
 	if err != nil {
@@ -84,15 +113,15 @@ func getPotdFromXML(htmlTable string) PotdEntry {
 	}
 
 	// attempt to find the descriptions node and url
-	descriptions := []string{}
-	var fileName, thumbnailUrl string
+	descriptions := []RichText{}
+	var fileName, filePageUrl, thumbnailUrl string
 	var foundFileName, foundThumbnailUrl bool
 	depthFirstTraverse(doc, func(n *html.Node) {
 		if n.Type == html.ElementNode && n.Data == "div" {
 			for _, attr := range n.Attr {
 				if attr.Key == "class" && slices.Contains(strings.Split(attr.Val, " "), "description") {
 					// found a description node
-					descriptions = append(descriptions, textDescription(n))
+					descriptions = append(descriptions, richTextFromHTML(n))
 					break
 				}
 			}
@@ -109,6 +138,11 @@ func getPotdFromXML(htmlTable string) PotdEntry {
 							} else {
 								fileName = attr2.Val[11:]
 								foundFileName = true
+								if pageUrl, err := commonsBaseUrl.Parse(attr2.Val); err != nil {
+									log.WithError(err).WithField("href", attr2.Val).Warn("could not resolve file description page URL")
+								} else {
+									filePageUrl = pageUrl.String()
+								}
 							}
 							break outer1
 						}
@@ -149,7 +183,7 @@ func getPotdFromXML(htmlTable string) PotdEntry {
 		log.WithField("descriptions", descriptions).Warn("expected one description, parsed html to find zero")
 
 		// insert zero value
-		descriptions = append(descriptions, "")
+		descriptions = append(descriptions, nil)
 	}
 
 	// expect to find both the filename and the thumbnail url
@@ -183,12 +217,31 @@ func getPotdFromXML(htmlTable string) PotdEntry {
 		downloadUrl = strings.Replace(thumbnailParts[0], "/thumb", "", 1) + fileName
 	}
 
-	return PotdEntry{Description: descriptions[0], DownloadUrl: downloadUrl}
+	return PotdEntry{Description: descriptions[0].PlainText(), RichDescription: descriptions[0], DownloadUrl: downloadUrl, FileName: fileName, FilePageUrl: filePageUrl, ThumbnailUrl: thumbnailUrl}
 }
 
-func getHtmlFromFeed() string {
+// FeedItem is the potd of one day in the feed, which lists the potds of the last few days.
+type FeedItem struct {
+	// Date is the Commons day of the potd, at midnight UTC.
+	Date time.Time
+	Html string
+}
+
+func getFeedItems(ctx context.Context, feedUrl string, language string) []FeedItem {
+	feed, err := url.Parse(feedUrl)
+	if err != nil {
+		log.WithError(err).WithField("feedUrl", feedUrl).Panic("unable to parse feed URL")
+	}
+	query := feed.Query()
+	query.Set("language", language)
+	feed.RawQuery = query.Encode()
+
 	// request feed via http
-	resp, err := http.Get("https://commons.wikimedia.org/w/api.php?action=featuredfeed&feed=potd&language=en")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.String(), nil)
+	if err != nil {
+		log.WithError(err).Panic("unable to create request for RSS feed")
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		log.WithError(err).Panic("unable to retrieve RSS feed via http")
 	}
@@ -200,9 +253,12 @@ func getHtmlFromFeed() string {
 		log.WithError(err).WithField("statusCode", resp.StatusCode).Panic("unable to read http response body after retrieving RSS feed")
 	}
 
-	// unmarshal the only field we need
+	// unmarshal the only fields we need
 	type FeedXML struct {
-		HtmlTable string `xml:"channel>item>description"`
+		Items []struct {
+			PubDate   string `xml:"pubDate"`
+			HtmlTable string `xml:"description"`
+		} `xml:"channel>item"`
 	}
 	var feedXml FeedXML
 	err = xml.Unmarshal(body, &feedXml)
@@ -210,127 +266,244 @@ func getHtmlFromFeed() string {
 		log.WithError(err).Panic("unable to unmarshal RSS XML feed")
 	}
 
-	return feedXml.HtmlTable
+	var items []FeedItem
+	for _, item := range feedXml.Items {
+		date, err := http.ParseTime(item.PubDate)
+		if err != nil {
+			log.WithError(err).WithField("pubDate", item.PubDate).Warn("skipping feed item with unreadable date")
+			continue
+		}
+		items = append(items, FeedItem{Date: date.UTC().Truncate(24 * time.Hour), Html: item.HtmlTable})
+	}
+	return items
 }
 
-func downloadFile(file *os.File, url string) {
-	// get the potd image via http
-	resp, err := http.Get(url)
-	if err != nil {
-		log.WithError(err).Panic("could not download potd image via http")
-	}
-	defer resp.Body.Close()
+// getHtmlFromFeed returns the description of the potd of the given day, which must be one of the last few days.
+func getHtmlFromFeed(ctx context.Context, feedUrl string, language string, date time.Time) string {
+	ctx, _, end := startSpan(ctx, "getHtmlFromFeed", attribute.String("language", language), attribute.String("date", date.Format(dateLayout)))
+	defer end()
 
-	// check http response
-	if resp.StatusCode != http.StatusOK {
-		log.WithFields(log.Fields{"statusCode": resp.StatusCode, "body": resp.Body}).Panic("bad http status while downloading potd image")
+	items := getFeedItems(ctx, feedUrl, language)
+	for _, item := range items {
+		if item.Date.Equal(date.UTC().Truncate(24 * time.Hour)) {
+			return item.Html
+		}
 	}
-
-	// write the download response body to the provided file
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		log.WithError(err).WithField("path", file.Name()).Panic("could not write potd image to file")
+	var dates []string
+	for _, item := range items {
+		dates = append(dates, item.Date.Format(dateLayout))
 	}
+	log.WithFields(log.Fields{"date": date.Format(dateLayout), "feedDates": dates}).Panic("potd of the day is not in the feed")
+	return ""
 }
 
-func compressFile(path string, quality int, fileSizeLimit int) string {
+// CompressOptions control how compressFile re-encodes the potd image.
+type CompressOptions struct {
+	// Quality is the JPEG quality used for photographs.
+	Quality int
+	// FileSizeLimit is the size in bytes which the output must be strictly below.
+	FileSizeLimit int
+	// MaxDimension is the largest width or height of the output.
+	MaxDimension int
+	// Background is the colour onto which transparent images are flattened.
+	Background color.RGBA
+	// ExifAllowlist names the EXIF fields, from those in exifFieldTags, which are kept in the output. All others are removed.
+	ExifAllowlist []string
+}
+
+func compressFile(ctx context.Context, path string, opts CompressOptions) string {
+	ctx, span, end := startSpan(ctx, "compressFile", attribute.String("path", path))
+	defer end()
+
+	backend := newImageBackend()
 	log.WithFields(log.Fields{
-		"fileSizeLimit": fileSizeLimit,
-		"jpegQuality":   quality,
+		"fileSizeLimit": opts.FileSizeLimit,
+		"maxDimension":  opts.MaxDimension,
+		"jpegQuality":   opts.Quality,
+		"background":    opts.Background,
+		"imageBackend":  backend.Name(),
 	}).Info("starting compression algorithm")
 
-	originalBuffer, err := bimg.Read(path)
+	originalBuffer, err := os.ReadFile(path)
 	if err != nil {
 		log.WithError(err).WithField("path", path).Panic("could not read input file to buffer")
 	}
 	size := len(originalBuffer)
-	log.WithField("size", size).Info("read the size of the original file")
-
-	// if the size of the file is already below Twitter's limit, just return its path
-	if size < fileSizeLimit {
-		log.Info("no image processing needed, file size is already below limit")
-		return path
+	format := detectFormat(originalBuffer)
+	span.SetAttributes(attribute.Int("originalSize", size), attribute.String("format", format))
+	log.WithFields(log.Fields{"size": size, "format": format}).Info("read the size and format of the original file")
+
+	// only fields on the allowlist are carried over, so location and camera serial numbers are always removed
+	metadata := readMetadata(originalBuffer)
+	exifSegment := allowedExifSegment(metadata, opts.ExifAllowlist)
+	needsColourConversion := false
+	if metadata.IccProfile != nil {
+		profile, err := parseIccProfile(metadata.IccProfile)
+		needsColourConversion = err != nil || !profile.IsSRGB()
+	}
+	log.WithFields(log.Fields{
+		"orientation":           metadata.Orientation,
+		"needsColourConversion": needsColourConversion,
+		"exifFields":            metadata.ExifFields,
+	}).Info("read image metadata")
+
+	// if the file is an upright sRGB JPEG and already below Twitter's limit, it only needs its metadata stripped,
+	// but other formats may have transparency or not be accepted by Twitter at all, so always re-encode those
+	fitsDimensions := func() bool {
+		dimensions, err := backend.Size(originalBuffer)
+		return err == nil && dimensions.Width <= opts.MaxDimension && dimensions.Height <= opts.MaxDimension
+	}
+	if format == FormatJPEG && size < opts.FileSizeLimit && metadata.Orientation <= 1 && !needsColourConversion && fitsDimensions() {
+		stripped, err := rewriteJpegMetadata(originalBuffer, exifSegment)
+		if err != nil {
+			log.WithError(err).Warn("could not strip metadata from JPEG, re-encoding it instead")
+		} else if bytes.Equal(stripped, originalBuffer) {
+			log.Info("no image processing needed, file size is already below limit")
+			observeCompression(size, size, 0)
+			return path
+		} else if len(stripped) < opts.FileSizeLimit {
+			log.Info("no image processing needed besides stripping metadata, file size is already below limit")
+			observeCompression(size, len(stripped), 0)
+			return writeCompressedFile(FormatJPEG, stripped)
+		}
+	}
+	if format == FormatSVG || format == FormatPDF || format == FormatDjVu || format == FormatUnknown {
+		log.WithField("format", format).Warn("expected a raster image, attempting to process it anyway")
 	}
 
-	dimensions, err := bimg.NewImage(originalBuffer).Size()
+	dimensions, err := backend.Size(originalBuffer)
 	if err != nil {
 		log.WithError(err).Panic("could not get image dimensions")
 	}
-
-	// uploaded images must be at most 4096x4096 in size
-	maxWidth := 4096
-	if dimensions.Height > dimensions.Width {
-		math.Floor((float64(4096) / float64(dimensions.Height)) * float64(dimensions.Width))
+	// the image will be rotated upright while it is resized
+	if swapsAxes(metadata.Orientation) {
+		dimensions.Width, dimensions.Height = dimensions.Height, dimensions.Width
 	}
-	minWidth := 1
-	var body []byte
 
-	// use binary search to find the highest resolution giving an acceptable file size
-	log.Info("starting binary search algorithm")
-	for maxWidth-minWidth >= 10 {
-		log.WithFields(log.Fields{"maxWidth": maxWidth, "minWidth": minWidth}).Info("unacceptable range, retrying")
-		testWidth := (maxWidth + minWidth) / 2
-		body, err = bimg.NewImage(originalBuffer).Process(bimg.Options{Width: testWidth, Quality: quality, Type: bimg.JPEG})
+	// diagrams, maps and logos stay sharper and smaller as PNG, whereas photographs are best encoded as JPEG
+	encodeOptions := EncodeOptions{Format: FormatJPEG, Quality: opts.Quality, Background: opts.Background}
+	lineArt, err := isLineArt(backend, originalBuffer, opts.Background)
+	if err != nil {
+		log.WithError(err).Warn("could not determine whether image is line art, assuming it is a photograph")
+	} else if lineArt {
+		encodeOptions.Format = FormatPNG
+	}
+	log.WithFields(log.Fields{"lineArt": lineArt, "outputFormat": encodeOptions.Format}).Info("chose output format")
+
+	// re-encode at the given width, then put back the allowlisted EXIF fields, which the backend strips
+	// the backend cannot be interrupted, so ctx is only checked before each re-encoding
+	iterations := 0
+	encode := func(width int) []byte {
+		if err := ctx.Err(); err != nil {
+			log.WithError(err).WithField("iterations", iterations).Panic("compression cancelled")
+		}
+		iterations++
+		_, span, end := startSpan(ctx, "compressFile.iteration", attribute.Int("iteration", iterations), attribute.Int("width", width))
+		defer end()
+
+		body, err := backend.Resize(originalBuffer, width, encodeOptions)
 		if err != nil {
-			log.WithError(err).WithField("width", testWidth).Panic("failed to execute re-encode operation")
+			log.WithError(err).WithField("width", width).Panic("failed to execute re-encode operation")
 		}
-		size = len(body)
-		if size >= fileSizeLimit {
-			maxWidth = testWidth
-		} else {
-			minWidth = testWidth
+		if encodeOptions.Format == FormatJPEG && exifSegment != nil {
+			body, err = rewriteJpegMetadata(body, exifSegment)
+			if err != nil {
+				log.WithError(err).Panic("could not insert EXIF fields into re-encoded image")
+			}
 		}
+		span.SetAttributes(attribute.Int("size", len(body)), attribute.Bool("acceptable", len(body) < opts.FileSizeLimit))
+		return body
 	}
 
-	finalDimensions, err := bimg.NewImage(body).Size()
-	if err != nil {
-		log.WithError(err).Panic("could not get final image dimensions")
+	// uploaded images must fit within the maximum dimensions, and there is no benefit in enlarging the image
+	maxWidth := opts.MaxDimension
+	if dimensions.Height > dimensions.Width {
+		maxWidth = int(math.Floor((float64(opts.MaxDimension) / float64(dimensions.Height)) * float64(dimensions.Width)))
+	}
+	if dimensions.Width < maxWidth {
+		maxWidth = dimensions.Width
 	}
 
-	log.WithFields(log.Fields{"size": size, "width": finalDimensions.Width, "height": finalDimensions.Height}).Info("an acceptable result was obtained")
+	// first try the largest permissible width, which will often suffice for small or re-encoded images
+	body := encode(maxWidth)
+	size = len(body)
 
-	err = bimg.Write("new.jpeg", body)
-	if err != nil {
-		log.WithError(err).Panic("could not write new image to disk")
+	if size >= opts.FileSizeLimit {
+		minWidth := 1
+		var acceptableBody []byte
+
+		// use binary search to find the highest resolution giving an acceptable file size
+		log.Info("starting binary search algorithm")
+		for acceptableBody == nil || maxWidth-minWidth >= 10 {
+			log.WithFields(log.Fields{"maxWidth": maxWidth, "minWidth": minWidth}).Info("unacceptable range, retrying")
+			testWidth := (maxWidth + minWidth) / 2
+			body = encode(testWidth)
+			if len(body) >= opts.FileSizeLimit {
+				maxWidth = testWidth
+			} else {
+				minWidth = testWidth
+				acceptableBody = body
+			}
+			if maxWidth-minWidth <= 1 && acceptableBody == nil {
+				log.WithField("width", testWidth).Panic("could not re-encode image below the size limit at any width")
+			}
+		}
+		body = acceptableBody
+		size = len(body)
 	}
 
-	cwd, err := os.Getwd()
+	finalDimensions, err := backend.Size(body)
 	if err != nil {
-		log.WithError(err).Panic("could not get current working directory")
+		log.WithError(err).Panic("could not get final image dimensions")
 	}
 
-	return filepath.Join(cwd, "new.jpeg")
-}
+	log.WithFields(log.Fields{"size": size, "width": finalDimensions.Width, "height": finalDimensions.Height, "iterations": iterations}).Info("an acceptable result was obtained")
+	observeCompression(len(originalBuffer), size, iterations)
+	span.SetAttributes(attribute.Int("size", size), attribute.Int("iterations", iterations))
 
-func getAuthorisedClient() *http.Client {
-	type Configuration struct {
-		ApiKey            string
-		ApiKeySecret      string
-		AccessToken       string
-		AccessTokenSecret string
-	}
+	return writeCompressedFile(encodeOptions.Format, body)
+}
 
-	confFile, err := os.Open("conf.json")
+// writeCompressedFile saves the output of compressFile to a new temporary file and returns its path.
+func writeCompressedFile(format string, body []byte) string {
+	file, err := os.CreateTemp("", "potdCompressed*."+format)
 	if err != nil {
-		log.WithError(err).Panic("unable to open configuration file")
+		log.WithError(err).Panic("could not create file for new image")
 	}
-	defer confFile.Close()
+	defer file.Close()
 
-	var conf Configuration
-	err = json.NewDecoder(confFile).Decode(&conf)
+	_, err = file.Write(body)
 	if err != nil {
-		log.WithError(err).Panic("unable to decode configuration file")
+		log.WithError(err).WithField("path", file.Name()).Panic("could not write new image to disk")
 	}
 
+	return file.Name()
+}
+
+func getAuthorisedClient(conf TwitterConfig) *http.Client {
 	// API Key and API Key Secret
 	config := oauth1.NewConfig(conf.ApiKey, conf.ApiKeySecret)
 	// Access Token and Access Token Secret
 	token := oauth1.NewToken(conf.AccessToken, conf.AccessTokenSecret)
 
-	return config.Client(oauth1.NoContext, token)
+	// base the client on the default one, so that its requests carry the User-Agent header and are measured
+	return config.Client(context.WithValue(oauth1.NoContext, oauth1.HTTPClient, http.DefaultClient), token)
 }
 
-func uploadImage(httpClient *http.Client, imagePath string) string {
+// postWithContext posts the body with the given client, cancelling the request with ctx.
+func postWithContext(ctx context.Context, httpClient *http.Client, url string, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return httpClient.Do(req)
+}
+
+func uploadImage(ctx context.Context, httpClient *http.Client, imagePath string) string {
+	ctx, span, end := startSpan(ctx, "uploadImage", attribute.String("path", imagePath))
+	defer end()
+
 	// create body form
 	b := &bytes.Buffer{}
 	form := multipart.NewWriter(b)
@@ -359,7 +532,7 @@ func uploadImage(httpClient *http.Client, imagePath string) string {
 	}
 
 	// upload media
-	resp, err := httpClient.Post("https://upload.twitter.com/1.1/media/upload.json?media_category=tweet_image", form.FormDataContentType(), bytes.NewReader(b.Bytes()))
+	resp, err := postWithContext(ctx, httpClient, "https://upload.twitter.com/1.1/media/upload.json?media_category=tweet_image", form.FormDataContentType(), b.Bytes())
 	if err != nil {
 		log.WithError(err).Panic("could not upload media to Twitter")
 	}
@@ -382,112 +555,30 @@ func uploadImage(httpClient *http.Client, imagePath string) string {
 	if err != nil {
 		log.WithError(err).Panic("could not decode Twitter API response and find id of uploaded media")
 	}
+	span.SetAttributes(attribute.Int("mediaId", m.MediaId))
 	return strconv.Itoa(m.MediaId)
 }
 
-func checkValid(text string) bool {
-	res, err := twtextparse.Parse(text)
-	if err != nil {
-		log.WithField("text", text).Panic("could not parse text to determine validity")
-	}
-	return res.IsValid
-}
-
-func tweetFromSlice(words []string) string {
-	return strings.Join(words, " ")
-}
+func postTweetWithImage(ctx context.Context, httpClient *http.Client, tweetBody string, mediaIds []string) string {
+	ctx, span, end := startSpan(ctx, "postTweetWithImage", attribute.StringSlice("mediaIds", mediaIds))
+	defer end()
 
-func TruncateTweetBody(text string) []string {
-	log.WithField("textInput", text).Info("starting to truncate text")
-
-	const ellipsis = "..."
-
-	var allTweets []string
-	allWords := strings.Fields(text)
-	previousAllWordsCount := len(allWords) + 1
-	leadingEllipsis := ""
-	for {
-		// attempt to fit the entire slice of remaining words into a single tweet
-		remainderString := leadingEllipsis + tweetFromSlice(allWords)
-
-		// if this constitutes a valid tweet, then add this as a tweet and end the loop
-		if checkValid(remainderString) {
-			allTweets = append(allTweets, remainderString)
-			log.WithField("tweet", remainderString).Info("generated final tweet")
-			break
-		}
-
-		// make an assertion that the first word (with a trailing ellipsis, and a leading one if necessary) alone can fit in a tweet,
-		// otherwise allWords can never decrease in size and an infinite loop will arise
-		validTweet := leadingEllipsis + tweetFromSlice(allWords[:1]) + ellipsis
-		if !checkValid(validTweet) {
-			log.WithFields(log.Fields{"longWord": tweetFromSlice(allWords[:1]), "resultingTweet": validTweet}).Panic("word cannot fit into a tweet by itself")
-		}
-
-		var currentWords []string
-		for {
-			// if there are no more words left to add, then break
-			if len(allWords) == 0 {
-				break
-			}
-
-			// if this is the last word left of allWords, then we do not need a trailing ellipsis
-			trailingEllipsis := ellipsis
-			if len(allWords) == 1 {
-				trailingEllipsis = ""
-			}
-
-			newWord := allWords[0]
-			testTweet := leadingEllipsis + tweetFromSlice(append(currentWords, newWord)) + trailingEllipsis
-			if checkValid(testTweet) {
-				// if this is a valid tweet,
-				// we can safely add newWord to the current tweet being constructed,
-				// and remove the word we just added from allWords
-				currentWords = append(currentWords, newWord)
-				allWords = allWords[1:]
-				// this test tweet was a valid one
-				validTweet = testTweet
-			} else {
-				// otherwise, the addition of the new word would make the tweet invalid, so do not add it
-				break
-			}
-		}
-
-		allTweets = append(allTweets, validTweet)
-
-		log.WithField("validTweet", validTweet).Info("generated one more valid tweet")
-
-		// a leading ellipsis will now be necessary for all subsequent tweets (all but the first one)
-		leadingEllipsis = ellipsis
-
-		// if the length of allWords does not decrease with each iteration of the loop, something has gone wrong
-		if len(allWords) >= previousAllWordsCount {
-			log.WithFields(log.Fields{"allWords": allWords, "previousAllWordsCount": previousAllWordsCount, "allTweets": allTweets, "validTweet": validTweet}).Panic("something has gone wrong and the length of allWords has not decreased during this iteration")
-		}
-	}
-
-	log.WithField("allTweets", allTweets).Info("finished generating tweets")
-
-	return allTweets
-}
-
-func postTweetWithImage(httpClient *http.Client, tweetBody string, mediaId string) string {
 	// create an object to be used in the http POST request to twitter
 	req := TweetRequestWithMedia{
 		Text: tweetBody,
 		Media: map[string][]string{
-			"media_ids": {mediaId},
+			"media_ids": mediaIds,
 		},
 	}
 
 	postBody, err := json.Marshal(req)
 	if err != nil {
-		log.WithError(err).WithFields(log.Fields{"text": tweetBody, "mediaId": mediaId}).Panic("could not marshal TweetRequestWithMedia object to JSON")
+		log.WithError(err).WithFields(log.Fields{"text": tweetBody, "mediaIds": mediaIds}).Panic("could not marshal TweetRequestWithMedia object to JSON")
 	}
 
 	log.WithField("requestBody", string(postBody)).Info("post body generated")
 
-	resp, err := httpClient.Post("https://api.twitter.com/2/tweets", "application/json", bytes.NewBuffer(postBody))
+	resp, err := postWithContext(ctx, httpClient, "https://api.twitter.com/2/tweets", "application/json", postBody)
 
 	// handle error
 	if err != nil {
@@ -512,10 +603,14 @@ func postTweetWithImage(httpClient *http.Client, tweetBody string, mediaId strin
 	if err != nil {
 		log.WithError(err).Panic("could not decode Twitter API response and find id of posted tweet")
 	}
+	span.SetAttributes(attribute.String("id", t.Data.Id))
 	return t.Data.Id
 }
 
-func postTweetInReply(httpClient *http.Client, tweetBody string, replyId string) string {
+func postTweetInReply(ctx context.Context, httpClient *http.Client, tweetBody string, replyId string) string {
+	ctx, span, end := startSpan(ctx, "postTweetInReply", attribute.String("replyId", replyId))
+	defer end()
+
 	// create an object to be used in the http POST request to twitter
 	req := TweetRequestInReply{
 		Text: tweetBody,
@@ -531,7 +626,7 @@ func postTweetInReply(httpClient *http.Client, tweetBody string, replyId string)
 
 	log.WithField("requestBody", string(postBody)).Info("post body generated for tweet")
 
-	resp, err := httpClient.Post("https://api.twitter.com/2/tweets", "application/json", bytes.NewBuffer(postBody))
+	resp, err := postWithContext(ctx, httpClient, "https://api.twitter.com/2/tweets", "application/json", postBody)
 
 	// handle error
 	if err != nil {
@@ -556,68 +651,19 @@ func postTweetInReply(httpClient *http.Client, tweetBody string, replyId string)
 	if err != nil {
 		log.WithError(err).Panic("could not decode Twitter API response and find id of posted tweet")
 	}
+	span.SetAttributes(attribute.String("id", t.Data.Id))
 	return t.Data.Id
 }
 
 func main() {
-	// set logging options
-	log.SetLevel(log.DebugLevel)
-	log.SetFormatter(&log.JSONFormatter{})
-	log.Info("logger started")
-
-	// fetch today's potd data from RSS Feed
-	potd := getPotdFromXML(getHtmlFromFeed())
-	log.WithField("potdEntry", potd).Info("fetched today's potd")
-
-	// create unique temporary file which will be overwritten by the potd image
-	tempFile, err := os.CreateTemp("", "potdFile")
-	if err != nil {
-		log.WithError(err).Panic("failed to create temporary file")
+	// without a command, run the whole pipeline, as earlier versions did
+	name, arguments := "run", os.Args[1:]
+	if len(arguments) > 0 && !strings.HasPrefix(arguments[0], "-") {
+		name, arguments = arguments[0], arguments[1:]
 	}
-	defer os.Remove(tempFile.Name())
-	log.WithField("path", tempFile.Name()).Info("created temporary file")
-
-	// download the potd image, saving it to the path of the temporary file
-	downloadFile(tempFile, potd.DownloadUrl)
-	log.WithFields(log.Fields{
-		"url":         potd.DownloadUrl,
-		"destination": tempFile.Name(),
-	}).Info("downloaded potd image")
-
-	// close the temporary file
-	err = tempFile.Close()
-	if err != nil {
-		log.WithError(err).Panic("could not close potd image file")
-	}
-
-	// resize image to fit Twitter's 5MB limit before uploading
-	compressedFile := compressFile(tempFile.Name(), 90, 5000000)
-	defer os.Remove(compressedFile)
-
-	// this Client will automatically authorize any requests to the Twitter API
-	httpClient := getAuthorisedClient()
-	log.Info("created http client")
-
-	mediaId := uploadImage(httpClient, compressedFile)
-	log.Info("potd image uploaded")
-
-	// generate batch of tweets to send out
-	tweetsBatch := TruncateTweetBody(potd.Description)
-
-	if len(tweetsBatch) >= 100 {
-		log.WithFields(log.Fields{"description": potd.Description, "tweetCount": len(tweetsBatch), "tweets": tweetsBatch}).Panic("too many tweets generated from description")
+	if name == "help" {
+		usage(os.Stdout)
+		return
 	}
-
-	// post initial tweet with image
-	id := postTweetWithImage(httpClient, tweetsBatch[0], mediaId)
-	log.WithField("id", id).Info("tweet posted with media")
-	tweetsBatch = tweetsBatch[1:]
-
-	// post each of the remaining tweets
-	for _, tweetText := range tweetsBatch {
-		id = postTweetInReply(httpClient, tweetText, id)
-		log.WithField("id", id).Info("tweet posted in reply to previous tweet")
-	}
-
-	log.Info("done posting tweets")
+	executeCommand(name, arguments)
 }
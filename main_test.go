@@ -6,49 +6,86 @@ import (
 	"testing"
 )
 
-// Test one continuous string comprised of 140 emoji characters, which should be left as-is.
+// allRules lists every length rule in a fixed order, so that the tests below run against each network.
+var allRules = []LengthRule{TwitterRule, MastodonRule, BlueskyRule, TelegramCaptionRule}
+
+// wordsBeforeEllipsis returns the most copies of word, separated by spaces and followed by "...",
+// which fit within the limit of the rule.
+func wordsBeforeEllipsis(rule LengthRule, word string) int {
+	count := 0
+	for rule.Length(strings.Repeat(word+" ", count+1)+"...")-rule.Length(" ") <= rule.Limit() {
+		count++
+	}
+	return count
+}
+
+// Test one continuous string comprised of as many emoji characters as fit (140 on Twitter), which should be left as-is.
 func TestTruncateEmojiUnchanged(t *testing.T) {
 	emojiArray := [4]string{"👾", "🙋🏽", "👨‍🎤", "👨‍👩‍👧‍👦"}
-	for _, emoji := range emojiArray {
-		longEmojiString := strings.Repeat(emoji, 140)
-		got := TruncateTweetBody(longEmojiString)
-		if len(got) != 1 || got[0] != longEmojiString {
-			t.Errorf("Repeated '%s' 140 times, got %s", emoji, got)
+	for _, rule := range allRules {
+		for _, emoji := range emojiArray {
+			count := rule.Limit() / rule.Length(emoji)
+			if rule == TwitterRule && count != 140 {
+				t.Errorf("expected 140 '%s' to fit in a tweet, got %d", emoji, count)
+			}
+			longEmojiString := strings.Repeat(emoji, count)
+			got := SplitThread(longEmojiString, EllipsisStyle, rule)
+			if len(got) != 1 || got[0] != longEmojiString {
+				t.Errorf("%s: repeated '%s' %d times, got %s", rule.Name(), emoji, count, got)
+			}
 		}
 	}
 }
 
-// Test string comprised of 100 copies of "e " where e is an emoji, which should be truncated.
+// Test string comprised of copies of "e " where e is an emoji, which should be truncated (after 92 on Twitter).
 func TestTruncateEmojiShorten(t *testing.T) {
 	emojiArray := [4]string{"👾", "🙋🏽", "👨‍🎤", "👨‍👩‍👧‍👦"}
-	for _, emoji := range emojiArray {
-		longEmojiString := strings.Repeat(emoji+" ", 100)
-		got := TruncateTweetBody(longEmojiString)
-		if got[0] != strings.TrimSuffix(strings.Repeat(emoji+" ", 92), " ")+"..." {
-			t.Errorf("Repeated '%s ' 100 times, got %s", emoji, got)
+	for _, rule := range allRules {
+		for _, emoji := range emojiArray {
+			count := wordsBeforeEllipsis(rule, emoji)
+			if rule == TwitterRule && count != 92 {
+				t.Errorf("expected 92 '%s ' before the ellipsis in a tweet, got %d", emoji, count)
+			}
+			longEmojiString := strings.Repeat(emoji+" ", count+10)
+			got := SplitThread(longEmojiString, EllipsisStyle, rule)
+			if got[0] != strings.TrimSuffix(strings.Repeat(emoji+" ", count), " ")+"..." {
+				t.Errorf("%s: repeated '%s ' %d times, got %s", rule.Name(), emoji, count+10, got)
+			}
 		}
 	}
 }
 
-// Test one continuous string comprised of 280 ascii characters, which should be left as-is
+// Test one continuous string comprised of as many characters as fit (280 on Twitter), which should be left as-is
 func TestTruncateTextUnchanged(t *testing.T) {
 	charArray := [4]string{"a", "A", ".", "Њ"}
-	for _, char := range charArray {
-		longTextString := strings.Repeat(char, 280)
-		got := TruncateTweetBody(longTextString)
-		if len(got) != 1 || got[0] != longTextString {
-			t.Errorf("Repeated '%s' 280 times, got %s", char, got)
+	for _, rule := range allRules {
+		for _, char := range charArray {
+			count := rule.Limit() / rule.Length(char)
+			if rule == TwitterRule && count != 280 {
+				t.Errorf("expected 280 '%s' to fit in a tweet, got %d", char, count)
+			}
+			longTextString := strings.Repeat(char, count)
+			got := SplitThread(longTextString, EllipsisStyle, rule)
+			if len(got) != 1 || got[0] != longTextString {
+				t.Errorf("%s: repeated '%s' %d times, got %s", rule.Name(), char, count, got)
+			}
 		}
 	}
 }
 
-// Test string comprised of 100 copies of ",,,  ", which should be truncated.
+// Test string comprised of copies of ",,,  ", which should be truncated (after 69 on Twitter).
 func TestTruncateTextShorten(t *testing.T) {
 	repeatingString := ",,,  "
-	longEmojiString := strings.Repeat(repeatingString, 100)
-	got := TruncateTweetBody(longEmojiString)
-	if got[0] != strings.TrimSuffix(strings.Repeat(",,, ", 69), " ")+"..." {
-		t.Errorf("Repeated '%s' 100 times, got %s", repeatingString, got)
+	for _, rule := range allRules {
+		count := wordsBeforeEllipsis(rule, ",,,")
+		if rule == TwitterRule && count != 69 {
+			t.Errorf("expected 69 ',,, ' before the ellipsis in a tweet, got %d", count)
+		}
+		longEmojiString := strings.Repeat(repeatingString, count+10)
+		got := SplitThread(longEmojiString, EllipsisStyle, rule)
+		if got[0] != strings.TrimSuffix(strings.Repeat(",,, ", count), " ")+"..." {
+			t.Errorf("%s: repeated '%s' %d times, got %s", rule.Name(), repeatingString, count+10, got)
+		}
 	}
 }
 
@@ -60,14 +97,14 @@ func TestComplexWikipediaSamples(t *testing.T) {
 	test3 := "At 12 cm (4.7 in), it is a small honeyeater with a short tail and relatively long down-curved bill. It is sexually dimorphic; the male has a glossy red head and brown upperparts and paler grey-brown underparts while the female has predominantly grey-brown plumage. Its natural habitat is subtropical or tropical mangrove forests. It is very active when feeding in the tree canopy, darting from flower to flower and gleaning insects off foliage. It calls constantly as it feeds. While little has been documented on the red-headed myzomela's breeding behaviour, it is recorded as building a small cup-shaped nest in the mangroves and laying two or three oval, white eggs with small red blotches."
 	actual3 := TruncateTweetBody(test3)
 
-	target1 := []string{"A yellow-bellied sapsucker (*Sphyrapicus varius*), a medium-sized woodpecker, perched on a tree in Central Park in New York City, New York, USA. These sapsuckers drill neatly organized rows of holes through which it does not \"suck\" the sap, but uses a brush-shaped tongue to...",
-		"...lap it up. The red coloring on its head and throat indicates a male."}
-	target2 := []string{"The red-headed myzomela or red-headed honeyeater (Myzomela erythrocephala) is a passerine bird of the honeyeater family Meliphagidae found in Australia, Indonesia, and Papua New Guinea. It was described by John Gould in 1840. Two subspecies are recognised, with the nominate...",
-		"...race M. e. erythrocephala distributed around the tropical coastline of Australia, and M. e. infuscata in New Guinea. Though widely distributed, it is not abundant within this range. While the IUCN lists the Australian population of M. e. infuscata as being near threatened,...",
-		"...as a whole the widespread range means that its conservation is of least concern."}
-	target3 := []string{"At 12 cm (4.7 in), it is a small honeyeater with a short tail and relatively long down-curved bill. It is sexually dimorphic; the male has a glossy red head and brown upperparts and paler grey-brown underparts while the female has predominantly grey-brown plumage. Its natural...",
-		"...habitat is subtropical or tropical mangrove forests. It is very active when feeding in the tree canopy, darting from flower to flower and gleaning insects off foliage. It calls constantly as it feeds. While little has been documented on the red-headed myzomela's breeding...",
-		"...behaviour, it is recorded as building a small cup-shaped nest in the mangroves and laying two or three oval, white eggs with small red blotches."}
+	target1 := []string{"A yellow-bellied sapsucker (*Sphyrapicus varius*), a medium-sized woodpecker, perched on a tree in Central Park in New York City, New York, USA.",
+		"These sapsuckers drill neatly organized rows of holes through which it does not \"suck\" the sap, but uses a brush-shaped tongue to lap it up. The red coloring on its head and throat indicates a male."}
+	target2 := []string{"The red-headed myzomela or red-headed honeyeater (Myzomela erythrocephala) is a passerine bird of the honeyeater family Meliphagidae found in Australia, Indonesia, and Papua New Guinea. It was described by John Gould in 1840.",
+		"Two subspecies are recognised, with the nominate race M. e. erythrocephala distributed around the tropical coastline of Australia, and M. e. infuscata in New Guinea. Though widely distributed, it is not abundant within this range.",
+		"While the IUCN lists the Australian population of M. e. infuscata as being near threatened, as a whole the widespread range means that its conservation is of least concern."}
+	target3 := []string{"At 12 cm (4.7 in), it is a small honeyeater with a short tail and relatively long down-curved bill. It is sexually dimorphic; the male has a glossy red head and brown upperparts and paler grey-brown underparts while the female has predominantly grey-brown plumage.",
+		"Its natural habitat is subtropical or tropical mangrove forests. It is very active when feeding in the tree canopy, darting from flower to flower and gleaning insects off foliage. It calls constantly as it feeds.",
+		"While little has been documented on the red-headed myzomela's breeding behaviour, it is recorded as building a small cup-shaped nest in the mangroves and laying two or three oval, white eggs with small red blotches."}
 
 	if !reflect.DeepEqual(actual1, target1) {
 		t.Errorf("test 1 failed, see logs for details")
@@ -79,3 +116,10 @@ func TestComplexWikipediaSamples(t *testing.T) {
 		t.Errorf("test 3 failed, see logs for details")
 	}
 }
+
+func TestGetPotdFilePageUrl(t *testing.T) {
+	potd := getPotdFromXML(`<a href="/wiki/File:Sapsucker_%28male%29.jpg" class="mw-file-description"><img src="https://upload.wikimedia.org/wikipedia/commons/thumb/a/ab/Sapsucker_%28male%29.jpg/300px-Sapsucker_%28male%29.jpg" class="mw-file-element"></a>`)
+	if expected := "https://commons.wikimedia.org/wiki/File:Sapsucker_%28male%29.jpg"; potd.FilePageUrl != expected {
+		t.Errorf("expected %q, got %q", expected, potd.FilePageUrl)
+	}
+}
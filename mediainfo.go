@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DepictedItem is a Wikidata item which a file depicts, according to a P180 statement in its structured data.
+type DepictedItem struct {
+	Id string
+	// Label is in the language of the potd feed if there is one, otherwise in English, otherwise empty.
+	Label string
+}
+
+// Coordinates are a position on Earth in degrees.
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// MediaInfo is the structured data which Commons holds for a file in its MediaInfo entity.
+type MediaInfo struct {
+	// Captions maps language codes to the caption in that language.
+	Captions map[string]string
+	Depicts  []DepictedItem
+	// Coordinates are where the picture was taken from, or failing that, where what it shows is, or nil if unknown.
+	Coordinates *Coordinates
+}
+
+// coordinateProperties are the properties giving the location of a file, in order of preference: coordinates of the
+// point of view, coordinates of depicted place, and coordinate location.
+var coordinateProperties = []string{"P1259", "P9149", "P625"}
+
+// mediaInfoId returns the id of the MediaInfo entity of the file with the given page id.
+func mediaInfoId(pageId int64) string {
+	return fmt.Sprintf("M%d", pageId)
+}
+
+// getMediaInfo fetches the MediaInfo entity with the given id, with the labels of depicted items in the given language.
+func getMediaInfo(ctx context.Context, id string, language string) (MediaInfo, error) {
+	type Statement struct {
+		Mainsnak struct {
+			Datavalue struct {
+				Value struct {
+					Id        string  `json:"id"`
+					Latitude  float64 `json:"latitude"`
+					Longitude float64 `json:"longitude"`
+				} `json:"value"`
+				Type string `json:"type"`
+			} `json:"datavalue"`
+		} `json:"mainsnak"`
+	}
+	var response struct {
+		Entities map[string]struct {
+			Missing *string `json:"missing"`
+			Labels  map[string]struct {
+				Value string `json:"value"`
+			} `json:"labels"`
+			Statements map[string][]Statement `json:"statements"`
+		} `json:"entities"`
+	}
+	err := getJson(ctx, commonsApiUrl, url.Values{
+		"action": {"wbgetentities"},
+		"format": {"json"},
+		"ids":    {id},
+		"props":  {"labels|claims"},
+	}, &response)
+	if err != nil {
+		return MediaInfo{}, err
+	}
+	entity, ok := response.Entities[id]
+	if !ok || entity.Missing != nil {
+		// files without any structured data have no entity at all
+		return MediaInfo{}, nil
+	}
+
+	// MediaInfo entities call their captions labels
+	info := MediaInfo{Captions: map[string]string{}}
+	for language, caption := range entity.Labels {
+		info.Captions[language] = caption.Value
+	}
+
+	var ids []string
+	for _, statement := range entity.Statements["P180"] {
+		if id := statement.Mainsnak.Datavalue.Value.Id; id != "" {
+			ids = append(ids, id)
+		}
+	}
+	// the labels only make the depicted items readable, so the rest of the structured data is kept without them
+	labels, err := getLabels(ctx, ids, language)
+	if err != nil {
+		log.WithError(err).WithField("ids", ids).Warn("could not fetch labels of depicted items")
+	}
+	for _, id := range ids {
+		info.Depicts = append(info.Depicts, DepictedItem{Id: id, Label: labels[id]})
+	}
+
+outer:
+	for _, property := range coordinateProperties {
+		for _, statement := range entity.Statements[property] {
+			if datavalue := statement.Mainsnak.Datavalue; datavalue.Type == "globecoordinate" {
+				info.Coordinates = &Coordinates{Latitude: datavalue.Value.Latitude, Longitude: datavalue.Value.Longitude}
+				break outer
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// maxEntityIds is the most ids which wbgetentities accepts in one request without the apihighlimits right.
+const maxEntityIds = 50
+
+// getLabels returns the labels of Wikidata items in the given language, falling back to English, keyed by id.
+// Items without a label in either are left out. The ids are requested in batches of maxEntityIds.
+func getLabels(ctx context.Context, ids []string, language string) (map[string]string, error) {
+	labels := map[string]string{}
+	for start := 0; start < len(ids); start += maxEntityIds {
+		batch := ids[start:min(start+maxEntityIds, len(ids))]
+		var response struct {
+			Entities map[string]struct {
+				Labels map[string]struct {
+					Value string `json:"value"`
+				} `json:"labels"`
+			} `json:"entities"`
+		}
+		err := getJson(ctx, wikidataApiUrl, url.Values{
+			"action":    {"wbgetentities"},
+			"format":    {"json"},
+			"ids":       {strings.Join(batch, "|")},
+			"props":     {"labels"},
+			"languages": {language + "|en"},
+		}, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, entity := range response.Entities {
+			if label, ok := entity.Labels[language]; ok {
+				labels[id] = label.Value
+			} else if label, ok := entity.Labels["en"]; ok {
+				labels[id] = label.Value
+			}
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetMediaInfo(t *testing.T) {
+	useCommonsFixture(t)
+
+	info, err := getMediaInfo(context.Background(), mediaInfoId(123), "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := MediaInfo{
+		Captions: map[string]string{
+			"en": "Male yellow-bellied sapsucker in Central Park",
+			"de": "Männlicher Gelbbauch-Saftlecker im Central Park",
+		},
+		Depicts:     []DepictedItem{{Id: "Q1043301", Label: "yellow-bellied sapsucker"}, {Id: "Q60", Label: "New York City"}},
+		Coordinates: &Coordinates{Latitude: 40.7812, Longitude: -73.9665},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("expected %+v, got %+v", expected, info)
+	}
+
+	// a file without structured data has no entity
+	info, err = getMediaInfo(context.Background(), mediaInfoId(404), "en")
+	if err != nil || !reflect.DeepEqual(info, MediaInfo{}) {
+		t.Errorf("expected empty structured data, got %+v (err %v)", info, err)
+	}
+}
+
+func TestPotdEntryCaption(t *testing.T) {
+	potd := PotdEntry{Captions: map[string]string{"en": "A woodpecker", "de": "Ein Specht"}}
+	for language, expected := range map[string]string{"de": "Ein Specht", "en": "A woodpecker", "fr": "A woodpecker"} {
+		if got := potd.Caption(language); got != expected {
+			t.Errorf("expected %q for %s, got %q", expected, language, got)
+		}
+	}
+	if got := (PotdEntry{}).Caption("en"); got != "" {
+		t.Errorf("expected no caption, got %q", got)
+	}
+}
+
+// Test that labels are requested in batches small enough for wbgetentities to accept.
+func TestGetLabelsBatches(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		ids := strings.Split(r.URL.Query().Get("ids"), "|")
+		if len(ids) > maxEntityIds {
+			w.Write([]byte(`{"error":{"code":"toomanyvalues"}}`))
+			return
+		}
+		entities := map[string]interface{}{}
+		for _, id := range ids {
+			entities[id] = map[string]interface{}{"labels": map[string]interface{}{"en": map[string]string{"value": "label of " + id}}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"entities": entities})
+	}))
+	defer server.Close()
+	defer func(previous string) { wikidataApiUrl = previous }(wikidataApiUrl)
+	wikidataApiUrl = server.URL
+
+	var ids []string
+	for i := 0; i < 120; i++ {
+		ids = append(ids, fmt.Sprintf("Q%d", i+1))
+	}
+	labels, err := getLabels(context.Background(), ids, "de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != len(ids) || labels["Q120"] != "label of Q120" || requests != 3 {
+		t.Errorf("expected a label for each of %d items in 3 requests, got %d labels in %d requests", len(ids), len(labels), requests)
+	}
+}
+
+// Test that the structured data is kept without labels when Wikidata cannot be reached.
+func TestGetMediaInfoWithoutLabels(t *testing.T) {
+	useCommonsFixture(t)
+	wikidata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer wikidata.Close()
+	wikidataApiUrl = wikidata.URL
+
+	info, err := getMediaInfo(context.Background(), mediaInfoId(123), "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []DepictedItem{{Id: "Q1043301"}, {Id: "Q60"}}
+	if !reflect.DeepEqual(info.Depicts, expected) || info.Coordinates == nil || info.Captions["en"] == "" {
+		t.Errorf("expected the structured data without labels, got %+v", info)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"sort"
+)
+
+// ImageMetadata holds the parts of an image's embedded metadata which affect how it is processed.
+type ImageMetadata struct {
+	// Orientation is the EXIF orientation, from 1 to 8, or 0 if absent.
+	Orientation int
+	// ExifFields holds the textual EXIF fields listed in exifFieldTags which are present in the image.
+	ExifFields map[string]string
+	// IccProfile is the raw embedded colour profile, or nil if absent.
+	IccProfile []byte
+}
+
+// exifFieldTags maps the names of the EXIF fields which may be kept in the output, as listed in
+// CompressOptions.ExifAllowlist, to their tag numbers. All of them are ASCII fields of IFD0.
+// Location and camera serial numbers live in other IFDs, so they can never be copied to the output.
+var exifFieldTags = map[string]uint16{
+	"ImageDescription": 0x010e,
+	"Make":             0x010f,
+	"Model":            0x0110,
+	"Software":         0x0131,
+	"DateTime":         0x0132,
+	"Artist":           0x013b,
+	"Copyright":        0x8298,
+}
+
+const exifOrientationTag = 0x0112
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+)
+
+// jpegSegment is a marker segment from the header of a JPEG file, with data excluding the length field.
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// splitJpeg returns the marker segments preceding the first scan of a JPEG file,
+// along with the remainder of the file starting at the start-of-scan marker.
+func splitJpeg(buf []byte) ([]jpegSegment, []byte, error) {
+	if !bytes.HasPrefix(buf, []byte{0xff, 0xd8}) {
+		return nil, nil, errors.New("missing JPEG start of image marker")
+	}
+
+	var segments []jpegSegment
+	i := 2
+	for {
+		if i+4 > len(buf) || buf[i] != 0xff {
+			return nil, nil, errors.New("malformed JPEG segment")
+		}
+		marker := buf[i+1]
+		// skip fill bytes
+		if marker == 0xff {
+			i++
+			continue
+		}
+		if marker == 0xda {
+			return segments, buf[i:], nil
+		}
+		length := int(binary.BigEndian.Uint16(buf[i+2:]))
+		if length < 2 || i+2+length > len(buf) {
+			return nil, nil, errors.New("JPEG segment length out of range")
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: buf[i+4 : i+2+length]})
+		i += 2 + length
+	}
+}
+
+// readMetadata extracts the EXIF orientation and fields and the colour profile from a JPEG or PNG file.
+// Other formats, and any metadata which cannot be parsed, result in empty metadata.
+func readMetadata(buf []byte) ImageMetadata {
+	metadata := ImageMetadata{ExifFields: map[string]string{}}
+
+	switch detectFormat(buf) {
+	case FormatJPEG:
+		segments, _, err := splitJpeg(buf)
+		if err != nil {
+			return metadata
+		}
+		// the profile may be split across several segments, each carrying its sequence number
+		iccChunks := map[byte][]byte{}
+		for _, segment := range segments {
+			if segment.marker == 0xe1 && bytes.HasPrefix(segment.data, exifHeader) {
+				parseExif(segment.data[len(exifHeader):], &metadata)
+			}
+			if segment.marker == 0xe2 && bytes.HasPrefix(segment.data, iccHeader) && len(segment.data) > len(iccHeader)+2 {
+				iccChunks[segment.data[len(iccHeader)]] = segment.data[len(iccHeader)+2:]
+			}
+		}
+		sequence := make([]int, 0, len(iccChunks))
+		for number := range iccChunks {
+			sequence = append(sequence, int(number))
+		}
+		sort.Ints(sequence)
+		for _, number := range sequence {
+			metadata.IccProfile = append(metadata.IccProfile, iccChunks[byte(number)]...)
+		}
+	case FormatPNG:
+		for i := 8; i+8 <= len(buf); {
+			length := int(binary.BigEndian.Uint32(buf[i:]))
+			chunkType := string(buf[i+4 : i+8])
+			if length < 0 || i+12+length > len(buf) {
+				break
+			}
+			data := buf[i+8 : i+8+length]
+			switch chunkType {
+			case "eXIf":
+				parseExif(data, &metadata)
+			case "iCCP":
+				// profile name, null separator, compression method and then the zlib stream
+				nameEnd := bytes.IndexByte(data, 0)
+				if nameEnd >= 0 && nameEnd+2 <= len(data) {
+					reader, err := zlib.NewReader(bytes.NewReader(data[nameEnd+2:]))
+					if err == nil {
+						metadata.IccProfile, _ = io.ReadAll(reader)
+					}
+				}
+			}
+			i += 12 + length
+		}
+	}
+
+	return metadata
+}
+
+// parseExif reads the orientation and allowlistable fields from IFD0 of a TIFF-structured EXIF block.
+func parseExif(tiff []byte, metadata *ImageMetadata) {
+	if len(tiff) < 8 {
+		return
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return
+	}
+
+	tagNames := map[uint16]string{}
+	for name, tag := range exifFieldTags {
+		tagNames[tag] = name
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			return
+		}
+		tag := order.Uint16(tiff[entry:])
+		valueType := order.Uint16(tiff[entry+2:])
+		valueCount := int(order.Uint32(tiff[entry+4:]))
+
+		// type 3 is SHORT and type 2 is ASCII
+		if tag == exifOrientationTag && valueType == 3 {
+			metadata.Orientation = int(order.Uint16(tiff[entry+8:]))
+		}
+		if name, ok := tagNames[tag]; ok && valueType == 2 {
+			// values of at most four bytes are stored in place of the offset
+			value := tiff[entry+8 : entry+12]
+			if valueCount > 4 {
+				offset := int(order.Uint32(tiff[entry+8:]))
+				if offset < 0 || offset+valueCount > len(tiff) {
+					continue
+				}
+				value = tiff[offset : offset+valueCount]
+			} else {
+				value = value[:valueCount]
+			}
+			metadata.ExifFields[name] = string(bytes.TrimRight(value, "\x00"))
+		}
+	}
+}
+
+// buildExifSegment encodes the given fields as the payload of a JPEG APP1 EXIF segment, or returns nil if there are none.
+func buildExifSegment(fields map[string]string) []byte {
+	var tags []uint16
+	values := map[uint16]string{}
+	for name, value := range fields {
+		if tag, ok := exifFieldTags[name]; ok {
+			tags = append(tags, tag)
+			values[tag] = value
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	// entries in an IFD must be sorted by tag
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	order := binary.LittleEndian
+	ifdSize := 2 + 12*len(tags) + 4
+	header := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	ifd := make([]byte, ifdSize)
+	var data []byte
+
+	order.PutUint16(ifd, uint16(len(tags)))
+	for i, tag := range tags {
+		value := append([]byte(values[tag]), 0)
+		entry := ifd[2+12*i:]
+		order.PutUint16(entry, tag)
+		order.PutUint16(entry[2:], 2)
+		order.PutUint32(entry[4:], uint32(len(value)))
+		if len(value) <= 4 {
+			copy(entry[8:12], value)
+		} else {
+			order.PutUint32(entry[8:], uint32(len(header)+ifdSize+len(data)))
+			data = append(data, value...)
+		}
+	}
+
+	segment := append([]byte{}, exifHeader...)
+	segment = append(segment, header...)
+	segment = append(segment, ifd...)
+	return append(segment, data...)
+}
+
+// allowedExifSegment returns the EXIF segment to embed in the output, containing only the allowlisted fields of the original.
+func allowedExifSegment(metadata ImageMetadata, allowlist []string) []byte {
+	fields := map[string]string{}
+	for _, name := range allowlist {
+		if value, ok := metadata.ExifFields[name]; ok {
+			fields[name] = value
+		}
+	}
+	return buildExifSegment(fields)
+}
+
+// rewriteJpegMetadata removes EXIF, XMP, IPTC and comment segments from a JPEG file without re-encoding it,
+// and inserts the given EXIF segment payload (if any) in their place.
+// JFIF, Adobe and colour profile segments are kept, since they affect how the image is decoded.
+func rewriteJpegMetadata(buf []byte, exif []byte) ([]byte, error) {
+	segments, scan, err := splitJpeg(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &bytes.Buffer{}
+	out.Write([]byte{0xff, 0xd8})
+	writeSegment := func(marker byte, data []byte) {
+		out.Write([]byte{0xff, marker})
+		binary.Write(out, binary.BigEndian, uint16(len(data)+2))
+		out.Write(data)
+	}
+
+	wroteExif := exif == nil
+	for _, segment := range segments {
+		// EXIF must follow the JFIF segment if there is one, and otherwise come first
+		if !wroteExif && segment.marker != 0xe0 {
+			writeSegment(0xe1, exif)
+			wroteExif = true
+		}
+		switch segment.marker {
+		case 0xe1, 0xed, 0xfe:
+			continue
+		}
+		writeSegment(segment.marker, segment.data)
+	}
+	if !wroteExif {
+		writeSegment(0xe1, exif)
+	}
+
+	out.Write(scan)
+	return out.Bytes(), nil
+}
+
+// swapsAxes reports whether an EXIF orientation rotates the image by a quarter turn.
+func swapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// applyOrientation transforms an image stored with the given EXIF orientation so that it displays upright.
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dstWidth, dstHeight := width, height
+	if swapsAxes(orientation) {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = width-1-x, y
+			case 3: // rotated 180
+				dx, dy = width-1-x, height-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, height-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = height-1-y, x
+			case 7: // transversed
+				dx, dy = height-1-y, width-1-x
+			case 8: // rotated 90 anticlockwise
+				dx, dy = y, width-1-x
+			}
+			dst.Set(dx, dy, src.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Build an EXIF block with the given orientation and textual fields.
+func exifWithOrientation(orientation int, fields map[string]string) []byte {
+	segment := buildExifSegment(fields)
+	tiff := segment[len(exifHeader):]
+
+	// prepend an orientation entry, shifting the offsets of the existing entries
+	count := int(binary.LittleEndian.Uint16(tiff[8:]))
+	out := append([]byte{}, tiff[:8]...)
+	out = binary.LittleEndian.AppendUint16(out, uint16(count+1))
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry, exifOrientationTag)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], uint16(orientation))
+	out = append(out, entry...)
+	for i := 0; i < count; i++ {
+		existing := append([]byte{}, tiff[10+12*i:22+12*i]...)
+		if binary.LittleEndian.Uint32(existing[4:]) > 4 {
+			binary.LittleEndian.PutUint32(existing[8:], binary.LittleEndian.Uint32(existing[8:])+12)
+		}
+		out = append(out, existing...)
+	}
+	out = append(out, tiff[10+12*count:]...)
+	return append(append([]byte{}, exifHeader...), out...)
+}
+
+// Encode a JPEG of random noise with the given EXIF block inserted.
+func jpegWithExif(t *testing.T, width int, height int, exif []byte) []byte {
+	img, err := png.Decode(bytes.NewReader(noisePng(t, width, height)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := rewriteJpegMetadata(buf.Bytes(), exif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestExifRoundTrip(t *testing.T) {
+	fields := map[string]string{"Artist": "Jane Doe", "Copyright": "CC BY-SA 4.0", "Model": "X"}
+	buf := jpegWithExif(t, 16, 8, exifWithOrientation(6, fields))
+
+	metadata := readMetadata(buf)
+	if metadata.Orientation != 6 {
+		t.Errorf("expected orientation 6, got %d", metadata.Orientation)
+	}
+	for name, value := range fields {
+		if metadata.ExifFields[name] != value {
+			t.Errorf("expected %s to be %q, got %q", name, value, metadata.ExifFields[name])
+		}
+	}
+
+	// only the allowlisted fields survive, and the orientation is dropped
+	stripped, err := rewriteJpegMetadata(buf, allowedExifSegment(metadata, []string{"Artist"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata = readMetadata(stripped)
+	if metadata.Orientation != 0 || len(metadata.ExifFields) != 1 || metadata.ExifFields["Artist"] != "Jane Doe" {
+		t.Errorf("expected only the artist to be kept, got orientation %d and fields %v", metadata.Orientation, metadata.ExifFields)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("stripped JPEG does not decode: %v", err)
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// a 2x1 image, red on the left and blue on the right
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	// rotating 90 degrees clockwise puts red on top
+	dst := applyOrientation(src, 6)
+	if dst.Bounds().Dx() != 1 || dst.Bounds().Dy() != 2 || dst.At(0, 0) != red || dst.At(0, 1) != blue {
+		t.Errorf("orientation 6 produced unexpected result")
+	}
+	// mirroring puts blue on the left
+	dst = applyOrientation(src, 2)
+	if dst.At(0, 0) != blue || dst.At(1, 0) != red {
+		t.Errorf("orientation 2 produced unexpected result")
+	}
+}
+
+// Build a matrix/TRC ICC profile with the given colorants and a pure gamma curve.
+func iccProfileBytes(colorants [3][3]float64, curve []byte) []byte {
+	tagNames := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"}
+	header := make([]byte, 128)
+	copy(header[16:], "RGB ")
+	copy(header[36:], "acsp")
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tagNames)))
+	var data []byte
+	offset := 128 + 4 + 12*len(tagNames)
+
+	for i, name := range tagNames {
+		var tag []byte
+		if i < 3 {
+			tag = append([]byte("XYZ "), 0, 0, 0, 0)
+			for _, v := range colorants[i] {
+				tag = binary.BigEndian.AppendUint32(tag, uint32(int32(v*65536)))
+			}
+		} else {
+			tag = curve
+		}
+		table = append(table, name...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(data)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag)))
+		data = append(data, tag...)
+	}
+	return append(append(header, table...), data...)
+}
+
+// gammaCurve returns a tone curve tag of a plain gamma.
+func gammaCurve(gamma float64) []byte {
+	tag := append([]byte("curv"), 0, 0, 0, 0, 0, 0, 0, 1)
+	tag = binary.BigEndian.AppendUint16(tag, uint16(gamma*256))
+	return append(tag, 0, 0)
+}
+
+// srgbCurve returns the parametric tone curve tag of sRGB, as written by most sRGB profiles.
+func srgbCurve() []byte {
+	tag := append([]byte("para"), 0, 0, 0, 0, 0, 3, 0, 0)
+	for _, p := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		tag = binary.BigEndian.AppendUint32(tag, uint32(int32(math.Round(p*65536))))
+	}
+	return tag
+}
+
+func TestIccConversion(t *testing.T) {
+	srgb, err := parseIccProfile(iccProfileBytes(srgbColorants, srgbCurve()))
+	if err != nil || !srgb.IsSRGB() {
+		t.Fatalf("expected sRGB profile to be recognised, got err %v", err)
+	}
+	for _, gamma := range []float64{1.8, 2.2} {
+		profile, err := parseIccProfile(iccProfileBytes(srgbColorants, gammaCurve(gamma)))
+		if err != nil || profile.IsSRGB() {
+			t.Errorf("expected profile with sRGB primaries but gamma %v not to be sRGB, got err %v", gamma, err)
+		}
+	}
+	// a curve which declares a gamma but ends before it
+	if _, err := parseIccProfile(iccProfileBytes(srgbColorants, gammaCurve(2.2)[:12])); err == nil {
+		t.Error("expected truncated tone curve to be rejected")
+	}
+
+	adobeColorants := [3][3]float64{
+		{0.6097559, 0.3111242, 0.0194811},
+		{0.2052401, 0.6256560, 0.0608902},
+		{0.1492240, 0.0632197, 0.7448387},
+	}
+	adobe, err := parseIccProfile(iccProfileBytes(adobeColorants, gammaCurve(2.2)))
+	if err != nil || adobe.IsSRGB() {
+		t.Fatalf("expected Adobe RGB profile not to be sRGB, got err %v", err)
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.NRGBA{R: 128, G: 128, B: 128, A: 255})
+	src.Set(1, 0, color.NRGBA{G: 255, A: 255})
+	dst := adobe.ConvertToSRGB(src)
+
+	// neutral colours stay neutral, while saturated Adobe RGB green is out of the sRGB gamut and clips
+	grey := dst.NRGBAAt(0, 0)
+	if grey.R != grey.G || grey.G != grey.B || grey.R < 120 || grey.R > 140 {
+		t.Errorf("expected grey to stay neutral, got %v", grey)
+	}
+	green := dst.NRGBAAt(1, 0)
+	if green.G != 255 || green.R != 0 || green.B != 0 {
+		t.Errorf("expected green to clip to sRGB green, got %v", green)
+	}
+}
+
+// Test that compressFile rotates images upright and keeps only allowlisted EXIF fields, even without resizing.
+func TestCompressFileMetadata(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "rotated.jpeg")
+	exif := exifWithOrientation(6, map[string]string{"Artist": "Jane Doe", "Model": "Camera"})
+	if err := os.WriteFile(path, jpegWithExif(t, 64, 32, exif), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := compressFile(context.Background(), path, CompressOptions{
+		Quality:       90,
+		FileSizeLimit: 5000000,
+		MaxDimension:  4096,
+		Background:    color.RGBA{R: 255, G: 255, B: 255, A: 255},
+		ExifAllowlist: []string{"Artist", "Copyright"},
+	})
+	defer os.Remove(got)
+	buf, err := os.ReadFile(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := newImageBackend().Size(buf)
+	if err != nil || size.Width != 32 || size.Height != 64 {
+		t.Errorf("expected upright 32x64 image, got %dx%d (err %v)", size.Width, size.Height, err)
+	}
+	metadata := readMetadata(buf)
+	if metadata.Orientation > 1 || metadata.ExifFields["Artist"] != "Jane Doe" || metadata.ExifFields["Model"] != "" {
+		t.Errorf("expected only the artist to be kept, got orientation %d and fields %v", metadata.Orientation, metadata.ExifFields)
+	}
+}
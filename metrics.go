@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// metricsNamespace prefixes the name of every metric.
+const metricsNamespace = "wikicommonspotd"
+
+var (
+	lastPostTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_post_timestamp_seconds",
+		Help:      "Time at which each account last finished posting the potd to each publisher.",
+	}, []string{"publisher", "account"})
+	threadLength = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "thread_length",
+		Help:      "Number of posts in the thread last posted by each account to each publisher.",
+	}, []string{"publisher", "account"})
+	downloadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "download_bytes_total",
+		Help:      "Bytes of potd images downloaded, including those of interrupted attempts.",
+	})
+	compressionRatio = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "compression_ratio",
+		Help:      "Size of the last compressed image as a fraction of the size of the original.",
+	})
+	compressionIterations = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "compression_iterations",
+		Help:      "Number of times the last compressed image was re-encoded to fit the size limit.",
+	})
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Time taken by HTTP requests to Wikimedia and the publishers, until the response headers arrive.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"host"})
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_requests_total",
+		Help:      "HTTP requests to Wikimedia and the publishers, by host and status code, which is \"error\" if there was no response.",
+	}, []string{"host", "code"})
+)
+
+// metricsRegistry holds every metric of the program, as well as those of the Go runtime and the process.
+var metricsRegistry = func() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		lastPostTimestamp, threadLength, downloadBytes, compressionRatio, compressionIterations, apiRequestDuration, apiRequests,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}()
+
+// metricsTransport records the latency and status code of every request.
+type metricsTransport struct {
+	base http.RoundTripper
+}
+
+func (t metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	apiRequestDuration.WithLabelValues(req.URL.Host).Observe(time.Since(start).Seconds())
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	apiRequests.WithLabelValues(req.URL.Host, code).Inc()
+	return resp, err
+}
+
+// observeCompression records the outcome of compressing an image.
+func observeCompression(originalSize int, compressedSize int, iterations int) {
+	if originalSize > 0 {
+		compressionRatio.Set(float64(compressedSize) / float64(originalSize))
+	}
+	compressionIterations.Set(float64(iterations))
+}
+
+// healthCheck serves /healthz, which reports the program unhealthy if the potd has not been completed for long.
+type healthCheck struct {
+	mutex       sync.Mutex
+	lastSuccess time.Time
+	maxAge      time.Duration
+	now         func() time.Time
+}
+
+// health is updated whenever an account completes the potd of a day.
+var health = &healthCheck{lastSuccess: time.Now(), maxAge: 26 * time.Hour, now: time.Now}
+
+// succeeded records that an account completed the potd at the given time.
+func (h *healthCheck) succeeded(at time.Time) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if at.After(h.lastSuccess) {
+		h.lastSuccess = at
+	}
+}
+
+func (h *healthCheck) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	age := h.now().Sub(h.lastSuccess)
+	h.mutex.Unlock()
+	if age > h.maxAge {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "unhealthy: nothing has been posted for %s\n", age.Round(time.Second))
+		return
+	}
+	fmt.Fprintf(w, "ok: last posted %s ago\n", age.Round(time.Second))
+}
+
+// serveMetrics listens on the address for requests for /metrics and /healthz until the program exits.
+// An account completing the potd of a day counts as a success even if its filter skipped the potd, and the time
+// the program started counts as one until the history has any.
+func serveMetrics(address string, maxAge time.Duration, history *History) {
+	entries, err := history.Entries()
+	if err != nil {
+		log.WithError(err).Warn("could not read history to find the last successful post")
+	}
+	var lastSuccess time.Time
+	for _, entry := range entries {
+		if entry.Complete && entry.Time.After(lastSuccess) {
+			lastSuccess = entry.Time
+		}
+	}
+	health.mutex.Lock()
+	health.maxAge = maxAge
+	if !lastSuccess.IsZero() {
+		health.lastSuccess = lastSuccess
+	}
+	health.mutex.Unlock()
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.WithError(err).WithField("address", address).Panic("could not listen for metrics requests")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	mux.Handle("/healthz", health)
+	log.WithField("address", listener.Addr().String()).Info("serving metrics")
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.WithError(err).Error("metrics listener stopped")
+		}
+	}()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHealthCheck(t *testing.T) {
+	now := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	check := &healthCheck{lastSuccess: now.Add(-30 * time.Hour), maxAge: 26 * time.Hour, now: func() time.Time { return now }}
+
+	recorder := httptest.NewRecorder()
+	check.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected unhealthy after 30 hours, got %d", recorder.Code)
+	}
+
+	check.succeeded(now.Add(-time.Hour))
+	check.succeeded(now.Add(-40 * time.Hour))
+	recorder = httptest.NewRecorder()
+	check.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected healthy an hour after a post, got %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestMetricsTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	host := server.Listener.Addr().String()
+	before := testutil.ToFloat64(apiRequests.WithLabelValues(host, "429"))
+	client := &http.Client{Transport: metricsTransport{base: http.DefaultTransport}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := testutil.ToFloat64(apiRequests.WithLabelValues(host, "429")) - before; got != 1 {
+		t.Errorf("expected one request counted with its status code, got %v", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"image"
+	"math"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxMediaPerPost is the number of images Twitter allows to be attached to a single post.
+const maxMediaPerPost = 4
+
+// PanoramaOptions control whether and how images with an extreme aspect ratio are split into several.
+type PanoramaOptions struct {
+	// Enabled turns on splitting.
+	Enabled bool
+	// MinAspectRatio is the ratio of the longer side to the shorter side above which an image is split.
+	MinAspectRatio float64
+	// TileAspectRatio is the ratio of the longer side to the shorter side which each tile aims for.
+	TileAspectRatio float64
+}
+
+// panoramaTiles divides an image of the given size into equal tiles along its longer side, such that each tile has
+// roughly the target aspect ratio, leaving room for the overview in the same post.
+// It returns no tiles if the image is not extreme enough to be worth splitting.
+func panoramaTiles(size ImageSize, opts PanoramaOptions) []image.Rectangle {
+	long, short := size.Width, size.Height
+	if size.Height > size.Width {
+		long, short = size.Height, size.Width
+	}
+	if short == 0 || float64(long)/float64(short) < opts.MinAspectRatio {
+		return nil
+	}
+
+	count := int(math.Ceil(float64(long) / float64(short) / opts.TileAspectRatio))
+	if count > maxMediaPerPost-1 {
+		count = maxMediaPerPost - 1
+	}
+	if count < 2 {
+		return nil
+	}
+
+	var tiles []image.Rectangle
+	for i := 0; i < count; i++ {
+		start, end := long*i/count, long*(i+1)/count
+		if size.Width >= size.Height {
+			tiles = append(tiles, image.Rect(start, 0, end, size.Height))
+		} else {
+			tiles = append(tiles, image.Rect(0, start, size.Width, end))
+		}
+	}
+	return tiles
+}
+
+// prepareImages compresses the potd image for upload, returning the paths of the images to attach to the first post.
+// If panorama splitting is enabled and the image has an extreme aspect ratio, the downscaled overview is followed by
+// full resolution crops of consecutive sections of it, each compressed in the same way.
+func prepareImages(ctx context.Context, path string, opts CompressOptions, panorama PanoramaOptions) []string {
+	paths := []string{compressFile(ctx, path, opts)}
+	if !panorama.Enabled {
+		return paths
+	}
+
+	backend := newImageBackend()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		log.WithError(err).WithField("path", path).Panic("could not read input file to buffer")
+	}
+	size, err := backend.Size(buf)
+	if err != nil {
+		log.WithError(err).Panic("could not get image dimensions")
+	}
+	if swapsAxes(readMetadata(buf).Orientation) {
+		size.Width, size.Height = size.Height, size.Width
+	}
+
+	tiles := panoramaTiles(size, panorama)
+	log.WithFields(log.Fields{"width": size.Width, "height": size.Height, "tiles": len(tiles)}).Info("checked aspect ratio for panorama splitting")
+
+	for i, tile := range tiles {
+		// crop losslessly, leaving the choice of output format and size to compressFile
+		cropped, err := backend.Crop(buf, tile, EncodeOptions{Format: FormatPNG, Background: opts.Background})
+		if err != nil {
+			log.WithError(err).WithField("tile", tile).Panic("could not crop panorama tile")
+		}
+		tilePath := writeCompressedFile(FormatPNG, cropped)
+		compressedPath := compressFile(ctx, tilePath, opts)
+		if compressedPath != tilePath {
+			os.Remove(tilePath)
+		}
+		paths = append(paths, compressedPath)
+		log.WithFields(log.Fields{"index": i, "tile": tile}).Info("prepared panorama tile")
+	}
+
+	return paths
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPanoramaTiles(t *testing.T) {
+	opts := PanoramaOptions{Enabled: true, MinAspectRatio: 3, TileAspectRatio: 16.0 / 9.0}
+	cases := []struct {
+		size     ImageSize
+		expected []image.Rectangle
+	}{
+		// not extreme enough
+		{ImageSize{Width: 2000, Height: 1000}, nil},
+		// a 4:1 panorama splits into three tiles, each about 4:3
+		{ImageSize{Width: 1200, Height: 300}, []image.Rectangle{image.Rect(0, 0, 400, 300), image.Rect(400, 0, 800, 300), image.Rect(800, 0, 1200, 300)}},
+		// a tall image splits vertically
+		{ImageSize{Width: 100, Height: 350}, []image.Rectangle{image.Rect(0, 0, 100, 175), image.Rect(0, 175, 100, 350)}},
+	}
+	for _, c := range cases {
+		if got := panoramaTiles(c.size, opts); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("tiles for %dx%d: expected %v, got %v", c.size.Width, c.size.Height, c.expected, got)
+		}
+	}
+}
+
+// Test that a panorama is prepared as an overview followed by tiles, and that splitting can be disabled.
+func TestPrepareImagesPanorama(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "panorama.png")
+	if err := os.WriteFile(path, noisePng(t, 1200, 200), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := CompressOptions{Quality: 90, FileSizeLimit: 5000000, MaxDimension: 4096, Background: color.RGBA{R: 255, G: 255, B: 255, A: 255}}
+	panorama := PanoramaOptions{MinAspectRatio: 3, TileAspectRatio: 16.0 / 9.0}
+
+	got := prepareImages(context.Background(), path, opts, panorama)
+	if len(got) != 1 {
+		t.Errorf("expected only the overview when splitting is disabled, got %v", got)
+	}
+
+	panorama.Enabled = true
+	got = prepareImages(context.Background(), path, opts, panorama)
+	expected := []ImageSize{{1200, 200}, {400, 200}, {400, 200}, {400, 200}}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d images, got %v", len(expected), got)
+	}
+	for i, imagePath := range got {
+		defer os.Remove(imagePath)
+		buf, err := os.ReadFile(imagePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size, err := newImageBackend().Size(buf)
+		if err != nil || size != expected[i] {
+			t.Errorf("image %d: expected %v, got %v (err %v)", i, expected[i], size, err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	nethtml "golang.org/x/net/html"
+)
+
+// commonsBaseUrl is used to resolve relative links in descriptions from the potd feed.
+var commonsBaseUrl = &url.URL{Scheme: "https", Host: "commons.wikimedia.org", Path: "/"}
+
+// RichText is a description with the small amount of formatting which every network can represent in some form,
+// as a list of paragraphs.
+type RichText []Paragraph
+
+// Paragraph is a run of text made up of consecutive spans.
+type Paragraph []Span
+
+// Span is text with uniform formatting.
+type Span struct {
+	Text   string
+	Italic bool
+	Bold   bool
+	// Link is the absolute URL which the text links to, or empty.
+	Link string
+}
+
+// sameFormat reports whether two spans can be merged into one.
+func (span Span) sameFormat(other Span) bool {
+	return span.Italic == other.Italic && span.Bold == other.Bold && span.Link == other.Link
+}
+
+// htmlWhitespace lists the characters which are collapsed when HTML is rendered, unlike other spaces such as &nbsp;.
+const htmlWhitespace = " \t\n\r\f"
+
+// blockElements end the current paragraph, both where they start and where they end.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "ul": true, "ol": true, "dl": true, "dd": true, "dt": true,
+	"blockquote": true, "table": true, "tr": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// richTextFromHTML converts the contents of an HTML node to rich text, keeping italic and bold text, links and
+// paragraphs and collapsing whitespace as a browser would. Links are resolved relative to Commons.
+func richTextFromHTML(node *nethtml.Node) RichText {
+	var result RichText
+	var paragraph Paragraph
+
+	endParagraph := func() {
+		// whitespace at the end of a paragraph is not rendered
+		for len(paragraph) > 0 {
+			last := &paragraph[len(paragraph)-1]
+			last.Text = strings.TrimRight(last.Text, htmlWhitespace)
+			if last.Text != "" {
+				break
+			}
+			paragraph = paragraph[:len(paragraph)-1]
+		}
+		if len(paragraph) > 0 {
+			result = append(result, paragraph)
+		}
+		paragraph = nil
+	}
+
+	addText := func(text string, format Span) {
+		// whitespace at the start of a paragraph, or following other whitespace, is not rendered
+		afterSpace := len(paragraph) == 0 || strings.HasSuffix(paragraph[len(paragraph)-1].Text, " ")
+		var builder strings.Builder
+		for _, r := range text {
+			if strings.ContainsRune(htmlWhitespace, r) {
+				if !afterSpace {
+					builder.WriteByte(' ')
+					afterSpace = true
+				}
+				continue
+			}
+			builder.WriteRune(r)
+			afterSpace = false
+		}
+		text = builder.String()
+		if text == "" {
+			return
+		}
+		format.Text = text
+		if len(paragraph) > 0 && paragraph[len(paragraph)-1].sameFormat(format) {
+			paragraph[len(paragraph)-1].Text += text
+			return
+		}
+		paragraph = append(paragraph, format)
+	}
+
+	var visit func(n *nethtml.Node, format Span)
+	visit = func(n *nethtml.Node, format Span) {
+		switch n.Type {
+		case nethtml.TextNode:
+			addText(n.Data, format)
+			return
+		case nethtml.ElementNode:
+			switch n.Data {
+			case "style", "script":
+				return
+			case "i", "em", "cite", "var":
+				format.Italic = true
+			case "b", "strong":
+				format.Bold = true
+			case "a":
+				if link := resolveLink(n); link != "" {
+					format.Link = link
+				}
+			}
+			if blockElements[n.Data] {
+				endParagraph()
+				defer endParagraph()
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c, format)
+		}
+	}
+	visit(node, Span{})
+	endParagraph()
+
+	return result
+}
+
+// plainTextFromHTML converts an HTML fragment, such as the artist reported by Commons, to plain text.
+func plainTextFromHTML(fragment string) string {
+	doc, err := nethtml.Parse(strings.NewReader(fragment))
+	if err != nil {
+		log.WithError(err).WithField("html", fragment).Warn("could not parse html, using it as it is")
+		return fragment
+	}
+	return richTextFromHTML(doc).PlainText()
+}
+
+// resolveLink returns the absolute URL of a link element, or an empty string if it does not link to a web page.
+func resolveLink(n *nethtml.Node) string {
+	for _, attr := range n.Attr {
+		if attr.Key != "href" {
+			continue
+		}
+		link, err := commonsBaseUrl.Parse(attr.Val)
+		if err != nil {
+			log.WithError(err).WithField("href", attr.Val).Warn("could not parse link in description")
+			return ""
+		}
+		if link.Scheme != "http" && link.Scheme != "https" {
+			return ""
+		}
+		return link.String()
+	}
+	return ""
+}
+
+// PlainText renders rich text without any formatting, with paragraphs separated by blank lines.
+func (text RichText) PlainText() string {
+	var paragraphs []string
+	for _, paragraph := range text {
+		var builder strings.Builder
+		for _, span := range paragraph {
+			builder.WriteString(span.Text)
+		}
+		paragraphs = append(paragraphs, builder.String())
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// HTML renders rich text as the HTML accepted by Mastodon and by Matrix in formatted_body.
+func (text RichText) HTML() string {
+	var builder strings.Builder
+	for _, paragraph := range text {
+		builder.WriteString("<p>")
+		for _, span := range paragraph {
+			writeHTMLSpan(&builder, span, "strong", "em")
+		}
+		builder.WriteString("</p>")
+	}
+	return builder.String()
+}
+
+// TelegramHTML renders rich text in Telegram's HTML parse mode, which has no paragraphs, so they are separated by
+// blank lines instead.
+func (text RichText) TelegramHTML() string {
+	var paragraphs []string
+	for _, paragraph := range text {
+		var builder strings.Builder
+		for _, span := range paragraph {
+			writeHTMLSpan(&builder, span, "b", "i")
+		}
+		paragraphs = append(paragraphs, builder.String())
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// writeHTMLSpan writes a span with the given tags for bold and italic text, nested inside any link.
+func writeHTMLSpan(builder *strings.Builder, span Span, boldTag string, italicTag string) {
+	if span.Link != "" {
+		builder.WriteString(`<a href="` + html.EscapeString(span.Link) + `">`)
+	}
+	if span.Bold {
+		builder.WriteString("<" + boldTag + ">")
+	}
+	if span.Italic {
+		builder.WriteString("<" + italicTag + ">")
+	}
+	builder.WriteString(html.EscapeString(span.Text))
+	if span.Italic {
+		builder.WriteString("</" + italicTag + ">")
+	}
+	if span.Bold {
+		builder.WriteString("</" + boldTag + ">")
+	}
+	if span.Link != "" {
+		builder.WriteString("</a>")
+	}
+}
+
+// BlueskyFacet annotates a range of a Bluesky post, given in bytes of its UTF-8 encoding, with a link.
+type BlueskyFacet struct {
+	Index struct {
+		ByteStart int `json:"byteStart"`
+		ByteEnd   int `json:"byteEnd"`
+	} `json:"index"`
+	Features []BlueskyFeature `json:"features"`
+}
+
+// BlueskyFeature is a feature of a facet. Only links are produced, as Bluesky has no italic or bold text.
+type BlueskyFeature struct {
+	Type string `json:"$type"`
+	Uri  string `json:"uri"`
+}
+
+// BlueskyText renders rich text as the plain text of a Bluesky post, together with facets for its links.
+func (text RichText) BlueskyText() (string, []BlueskyFacet) {
+	var builder strings.Builder
+	var facets []BlueskyFacet
+	for i, paragraph := range text {
+		if i > 0 {
+			builder.WriteString("\n\n")
+		}
+		for _, span := range paragraph {
+			start := builder.Len()
+			builder.WriteString(span.Text)
+			if span.Link == "" {
+				continue
+			}
+			facet := BlueskyFacet{Features: []BlueskyFeature{{Type: "app.bsky.richtext.facet#link", Uri: span.Link}}}
+			facet.Index.ByteStart, facet.Index.ByteEnd = start, builder.Len()
+			facets = append(facets, facet)
+		}
+	}
+	return builder.String(), facets
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const descriptionFixture = `<table><tr><td><div class="description en">A <a href="/wiki/Yellow-bellied_sapsucker">yellow-bellied
+    sapsucker</a> (<i>Sphyrapicus varius</i>), a <b>medium-sized</b> woodpecker.<br>
+  The red coloring on its head &amp; throat indicates a <a href="https://en.wikipedia.org/wiki/Male"><i>male</i></a>. </div></td></tr></table>`
+
+func TestGetPotdRichDescription(t *testing.T) {
+	potd := getPotdFromXML(descriptionFixture)
+	expected := RichText{
+		{
+			{Text: "A "},
+			{Text: "yellow-bellied sapsucker", Link: "https://commons.wikimedia.org/wiki/Yellow-bellied_sapsucker"},
+			{Text: " ("},
+			{Text: "Sphyrapicus varius", Italic: true},
+			{Text: "), a "},
+			{Text: "medium-sized", Bold: true},
+			{Text: " woodpecker."},
+		},
+		{
+			{Text: "The red coloring on its head & throat indicates a "},
+			{Text: "male", Italic: true, Link: "https://en.wikipedia.org/wiki/Male"},
+			{Text: "."},
+		},
+	}
+	if !reflect.DeepEqual(potd.RichDescription, expected) {
+		t.Errorf("expected %+v, got %+v", expected, potd.RichDescription)
+	}
+	if strings.Contains(potd.Description, "*") {
+		t.Errorf("expected no markup in plain text, got %q", potd.Description)
+	}
+}
+
+func TestRichTextRenderers(t *testing.T) {
+	text := getPotdFromXML(descriptionFixture).RichDescription
+
+	plain := "A yellow-bellied sapsucker (Sphyrapicus varius), a medium-sized woodpecker.\n\nThe red coloring on its head & throat indicates a male."
+	if got := text.PlainText(); got != plain {
+		t.Errorf("plain text: expected %q, got %q", plain, got)
+	}
+
+	expected := `<p>A <a href="https://commons.wikimedia.org/wiki/Yellow-bellied_sapsucker">yellow-bellied sapsucker</a> (<em>Sphyrapicus varius</em>), a <strong>medium-sized</strong> woodpecker.</p>` +
+		`<p>The red coloring on its head &amp; throat indicates a <a href="https://en.wikipedia.org/wiki/Male"><em>male</em></a>.</p>`
+	if got := text.HTML(); got != expected {
+		t.Errorf("HTML: expected %q, got %q", expected, got)
+	}
+
+	expected = `A <a href="https://commons.wikimedia.org/wiki/Yellow-bellied_sapsucker">yellow-bellied sapsucker</a> (<i>Sphyrapicus varius</i>), a <b>medium-sized</b> woodpecker.` +
+		"\n\n" + `The red coloring on its head &amp; throat indicates a <a href="https://en.wikipedia.org/wiki/Male"><i>male</i></a>.`
+	if got := text.TelegramHTML(); got != expected {
+		t.Errorf("Telegram HTML: expected %q, got %q", expected, got)
+	}
+
+	got, facets := text.BlueskyText()
+	if got != plain {
+		t.Errorf("Bluesky: expected %q, got %q", plain, got)
+	}
+	if len(facets) != 2 {
+		t.Fatalf("expected two link facets, got %+v", facets)
+	}
+	for i, link := range []string{"yellow-bellied sapsucker", "male"} {
+		if covered := got[facets[i].Index.ByteStart:facets[i].Index.ByteEnd]; covered != link {
+			t.Errorf("expected facet %d to cover %q, got %q", i, link, covered)
+		}
+	}
+}
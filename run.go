@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// panicError converts a value recovered from a panic, usually a log entry from log.Panic, into an error. Credentials
+// are redacted from its message, since the log entry it was made from did not pass through redactHook, and the
+// error goes on to be logged and sent in alerts.
+func panicError(recovered interface{}) error {
+	if entry, ok := recovered.(*log.Entry); ok {
+		if err, ok := entry.Data[log.ErrorKey].(error); ok {
+			return fmt.Errorf("%s: %w", redact(entry.Message), redactedError{err})
+		}
+		return errors.New(redact(entry.Message))
+	}
+	return errors.New(redact(fmt.Sprintf("%v", recovered)))
+}
+
+// errorKind classifies a value recovered from a panic, so that alerts can tell passing network trouble from bugs.
+func errorKind(recovered interface{}) string {
+	err := panicError(recovered)
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	if entry, ok := recovered.(*log.Entry); ok {
+		if _, ok := entry.Data["statusCode"]; ok {
+			return "http"
+		}
+	}
+	return "internal"
+}
+
+// AccountFailure describes why an account could not post the potd.
+type AccountFailure struct {
+	Account string `json:"account"`
+	// Stage is fetch if the potd could not be fetched in the language of the account, and otherwise post.
+	Stage string `json:"stage"`
+	Kind  string `json:"kind"`
+	Error string `json:"error"`
+	// PostIds are the posts of the thread which were made before the failure.
+	PostIds []string `json:"postIds,omitempty"`
+}
+
+// RunError describes a failed run: the stage at which it stopped, the kind of error which stopped it, the potd if it
+// was fetched, and the accounts which could not post it.
+type RunError struct {
+	Date     time.Time
+	Stage    string
+	Kind     string
+	Potd     *PotdEntry
+	Accounts []AccountFailure
+	Err      error
+}
+
+func (e *RunError) Error() string {
+	return fmt.Sprintf("%s failed (%s): %v", e.Stage, e.Kind, e.Err)
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// fetchPotd returns the potd of the given day in the given language, with its structured data, and the imageinfo
+// of the file. The feed item and the imageinfo are cached, so that a potd which was fetched before can be posted
+// while Commons cannot be reached.
+func fetchPotd(ctx context.Context, config Config, cache *ImageCache, language string, date time.Time) (PotdEntry, ImageInfo) {
+	potd := getPotdFromXML(cachedFeedHtml(ctx, cache, config.FeedUrl, language, date))
+	log.WithFields(log.Fields{"language": language, "date": date.Format(dateLayout), "potdEntry": potd}).Info("fetched potd")
+
+	info := cachedImageInfo(ctx, cache, potd.FileName)
+	potd.addMediaInfo(ctx, info.PageId, language)
+	if potd.Coordinates == nil {
+		potd.Coordinates = info.Coordinates
+	}
+	return potd, info
+}
+
+// fetchForAccount fetches the potd again in the language of an account, within FetchTimeout. If it cannot be
+// fetched, a failure of the account is returned instead, so that the other accounts still post.
+func fetchForAccount(ctx context.Context, config Config, cache *ImageCache, account AccountConfig, date time.Time) (potd PotdEntry, failure *AccountFailure) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err := panicError(recovered)
+			log.WithError(err).WithFields(log.Fields{"account": account.Name, "language": account.Language}).Error("could not fetch potd in the language of the account")
+			failure = &AccountFailure{Account: account.Name, Stage: "fetch", Kind: errorKind(recovered), Error: err.Error()}
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.FetchTimeout))
+	defer cancel()
+	potd, _ = fetchPotd(ctx, config, cache, account.Language, date)
+	return potd, nil
+}
+
+// downloadPotd returns the path of the potd image, downloading it unless it is already cached, and the key under
+// which it is cached. The returned function must be called once the file is no longer needed.
+func downloadPotd(ctx context.Context, config Config, cache *ImageCache, info ImageInfo) (string, string, func()) {
+	// vector and multi-page formats are fetched as a raster rendition from the Commons thumbnailer instead
+	// only the original file can be verified against the SHA-1 reported by Commons
+	sourceUrl := renderedUrl(info, config.RenderWidth)
+	sourceKey := info.Sha1
+	downloadOptions := DownloadOptions{Timeout: time.Duration(config.DownloadTimeout), MaxBytes: config.DownloadMaxBytes, MaxAttempts: config.DownloadMaxAttempts}
+	if sourceUrl == info.Url {
+		downloadOptions.Sha1 = info.Sha1
+	} else {
+		sourceKey += "-" + optionsKey(sourceUrl)
+	}
+	path, cleanup := downloadCached(ctx, cache, sourceKey, sourceUrl, downloadOptions)
+	return path, sourceKey, cleanup
+}
+
+// compressOptions returns the settings with which images are prepared for upload.
+func compressOptions(config Config) (CompressOptions, PanoramaOptions) {
+	return CompressOptions{
+		Quality:       config.JpegQuality,
+		FileSizeLimit: config.FileSizeLimit,
+		MaxDimension:  config.MaxDimension,
+		Background:    color.RGBA(config.Background),
+		ExifAllowlist: config.ExifAllowlist,
+	}, PanoramaOptions{
+		Enabled:         config.SplitPanoramas,
+		MinAspectRatio:  3,
+		TileAspectRatio: 16.0 / 9.0,
+	}
+}
+
+// today returns the current Commons day, at midnight UTC.
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// runOnce posts the potd of the given day for every account which has not already completed it according to the
+// history, returning a *RunError if any of them failed. Each stage must finish within its timeout in the
+// configuration. Cancelling ctx aborts the request in flight, and no further accounts are started; the posts made so
+// far are in the history, from which the next run continues. Each run is traced as a span with the stages within it.
+func runOnce(ctx context.Context, config Config, history *History, date time.Time) (err error) {
+	// the run id ties the trace to the logs of the run
+	ctx, span, end := startSpan(ctx, "run", attribute.String("date", date.Format(dateLayout)), attribute.String("runId", runId.get()))
+	defer end()
+	stage := "history"
+	var fetched *PotdEntry
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = &RunError{Date: date, Stage: stage, Kind: errorKind(recovered), Potd: fetched, Err: panicError(recovered)}
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}()
+
+	var pending []AccountConfig
+	for _, account := range config.Accounts {
+		entry, _, err := history.Get(date, account.Name)
+		if err != nil {
+			return &RunError{Date: date, Stage: stage, Kind: "internal", Err: fmt.Errorf("could not read history: %w", err)}
+		}
+		if entry.Complete {
+			log.WithFields(log.Fields{"account": account.Name, "date": entry.Date}).Info("potd already posted for account")
+			continue
+		}
+		pending = append(pending, account)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// originals and compressed images are cached by the SHA-1 of the original file on Commons
+	cache := &ImageCache{Dir: config.CacheDir, MaxBytes: config.CacheMaxBytes}
+	stage = "fetch"
+	fetchCtx, cancelFetch := context.WithTimeout(ctx, time.Duration(config.FetchTimeout))
+	defer cancelFetch()
+	potd, info := fetchPotd(fetchCtx, config, cache, pending[0].Language, date)
+	fetched = &potd
+
+	// the description and the labels of depicted items depend on the language, so the potd is fetched again
+	// for each further language in which it is posted
+	potds := map[string]PotdEntry{pending[0].Language: potd}
+	categories, categoriesErr := getCategories(fetchCtx, potd.FileName)
+	if categoriesErr != nil {
+		log.WithError(categoriesErr).WithField("fileName", potd.FileName).Warn("could not fetch categories of file")
+	}
+
+	// download the potd image, unless it is already cached, within DownloadTimeout
+	stage = "download"
+	sourceFile, sourceKey, removeSourceFile := downloadPotd(ctx, config, cache, info)
+	defer removeSourceFile()
+
+	// resize image to fit Twitter's 5MB limit before uploading, splitting it up if it is a panorama
+	stage = "compress"
+	compressCtx, cancelCompress := context.WithTimeout(ctx, time.Duration(config.CompressTimeout))
+	defer cancelCompress()
+	opts, panorama := compressOptions(config)
+	compressedFiles, removeCompressedFiles := prepareImagesCached(compressCtx, cache, sourceKey, sourceFile, opts, panorama)
+	defer removeCompressedFiles()
+
+	shared := SharedPotd{
+		Date:          date,
+		Info:          info,
+		Categories:    categories,
+		CategoriesErr: categoriesErr,
+		Images:        compressedFiles,
+		Gazetteer:     loadGazetteer(config.Gazetteer),
+	}
+	stage = "post"
+	var failures []AccountFailure
+	for i, account := range pending {
+		if ctx.Err() != nil {
+			for _, account := range pending[i:] {
+				failures = append(failures, AccountFailure{Account: account.Name, Stage: stage, Kind: "cancelled", Error: ctx.Err().Error()})
+			}
+			log.WithError(ctx.Err()).Warn("stopping before posting for the remaining accounts")
+			break
+		}
+
+		potd, ok := potds[account.Language]
+		if !ok {
+			var failure *AccountFailure
+			if potd, failure = fetchForAccount(ctx, config, cache, account, date); failure != nil {
+				failures = append(failures, *failure)
+				continue
+			}
+			potds[account.Language] = potd
+		}
+
+		log.WithField("account", account.Name).Info("posting potd for account")
+		postCtx, cancelPost := context.WithTimeout(ctx, time.Duration(config.PostTimeout))
+		failure := runAccount(postCtx, account, potd, shared, history)
+		cancelPost()
+		if failure != nil {
+			failures = append(failures, *failure)
+		}
+	}
+	if len(failures) > 0 {
+		var failed []string
+		for _, failure := range failures {
+			failed = append(failed, failure.Account)
+		}
+		return &RunError{Date: date, Stage: failures[0].Stage, Kind: failures[0].Kind, Potd: fetched, Accounts: failures, Err: fmt.Errorf("could not post potd for accounts %v", failed)}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that a potd which cannot be fetched in the language of an account fails only that account, at the fetch stage.
+func TestFetchForAccountFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	config := defaultConfig()
+	config.FeedUrl = server.URL
+	account := AccountConfig{Name: "german", Language: "de"}
+	_, failure := fetchForAccount(context.Background(), config, nil, account, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	if failure == nil || failure.Account != "german" || failure.Stage != "fetch" {
+		t.Errorf("expected a fetch failure of the account, got %+v", failure)
+	}
+}
+
+// twitterFixture is the state of the fake Twitter API in TestRunOnce, by the API key of the account making the request.
+type twitterFixture struct {
+	mu        sync.Mutex
+	uploads   map[string][]string
+	tweets    map[string][]string
+	replies   map[string][]string
+	failing   map[string]bool
+	downloads int
+	nextId    int
+}
+
+var consumerKeyPattern = regexp.MustCompile(`oauth_consumer_key="([^"]*)"`)
+
+// Test that a run with several accounts downloads and compresses the potd once, records each account in the history,
+// continues an interrupted thread without posting its first tweet again, records a filtered account as skipped,
+// and carries on after an account fails, which the next run then posts for alone.
+func TestRunOnce(t *testing.T) {
+	image := noisePng(t, 64, 48)
+	sum := sha1.Sum(image)
+	description := strings.TrimSpace(strings.Repeat("sapsucker ", 40))
+	fixture := &twitterFixture{uploads: map[string][]string{}, tweets: map[string][]string{}, replies: map[string][]string{}, failing: map[string]bool{"failing": true}, nextId: 100}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fixture.mu.Lock()
+		defer fixture.mu.Unlock()
+		query := r.URL.Query()
+		key := ""
+		if match := consumerKeyPattern.FindStringSubmatch(r.Header.Get("Authorization")); match != nil {
+			key = match[1]
+		}
+		switch {
+		case r.URL.Path == "/feed":
+			item := `<a href="/wiki/File:Example.png" class="mw-file-description"><img src="https://upload.wikimedia.org/wikipedia/commons/thumb/a/ab/Example.png/300px-Example.png" class="mw-file-element"></a>` +
+				`<div class="description en">` + description + `</div>`
+			w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel><title>Picture of the day</title>` +
+				`<item><title>1 March</title><pubDate>Fri, 01 Mar 2024 00:00:00 GMT</pubDate><description>` + html.EscapeString(item) + `</description></item>` +
+				`</channel></rss>`))
+		case query.Get("prop") == "imageinfo":
+			fmt.Fprintf(w, `{"query":{"pages":{"123":{"pageid":123,"imageinfo":[{"url":"https://upload.wikimedia.org/wikipedia/commons/a/ab/Example.png","width":64,"height":48,"size":%d,"sha1":"%x"}]}}}}`, len(image), sum)
+		case query.Get("prop") == "categories":
+			w.Write([]byte(`{"query":{"pages":{"123":{"pageid":123,"title":"File:Example.png","categories":[{"title":"Category:Pictures of the day"}]}}}}`))
+		case query.Get("action") == "wbgetentities":
+			w.Write([]byte(`{"entities":{"M123":{"type":"mediainfo","id":"M123","labels":{},"statements":{}}},"success":1}`))
+		case r.URL.Path == "/wikipedia/commons/a/ab/Example.png":
+			fixture.downloads++
+			w.Write(image)
+		case fixture.failing[key]:
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path == "/1.1/media/upload.json":
+			file, header, err := r.FormFile("media")
+			if err != nil {
+				t.Errorf("could not read upload: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			file.Close()
+			fixture.uploads[key] = append(fixture.uploads[key], header.Filename)
+			w.Write([]byte(`{"media_id": 7}`))
+		case r.URL.Path == "/2/tweets":
+			var tweet struct {
+				Reply struct {
+					InReplyTo string `json:"in_reply_to_tweet_id"`
+				} `json:"reply"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&tweet); err != nil {
+				t.Errorf("could not read tweet: %v", err)
+			}
+			fixture.nextId++
+			id := strconv.Itoa(fixture.nextId)
+			if tweet.Reply.InReplyTo == "" {
+				fixture.tweets[key] = append(fixture.tweets[key], id)
+			} else {
+				fixture.replies[key] = append(fixture.replies[key], tweet.Reply.InReplyTo)
+			}
+			fmt.Fprintf(w, `{"data": {"id": "%s"}}`, id)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := http.DefaultClient.Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+	http.DefaultClient.Transport = redirectTransport{server: serverUrl}
+	commons := commonsApiUrl
+	defer func() { commonsApiUrl = commons }()
+	commonsApiUrl = server.URL + "/w/api.php"
+
+	config := defaultConfig()
+	config.FeedUrl = server.URL + "/feed"
+	config.CacheDir = t.TempDir()
+	account := func(name string) AccountConfig {
+		account := config.AccountConfig
+		account.Name = name
+		account.Twitter.ApiKey = name
+		return account
+	}
+	portrait := account("portrait")
+	portrait.Filter.Orientation = "portrait"
+	config.Accounts = []AccountConfig{account("failing"), account("resumed"), account("fresh"), portrait}
+
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	history := &History{Path: filepath.Join(t.TempDir(), "history.jsonl")}
+	if err := history.Record(HistoryEntry{Date: "2024-03-01", Account: "resumed", FileName: "Example.png", PostIds: []string{"1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var runErr *RunError
+	if err := runOnce(context.Background(), config, history, date); !errors.As(err, &runErr) {
+		t.Fatalf("expected a run error, got %v", err)
+	}
+	if len(runErr.Accounts) != 1 || runErr.Accounts[0].Account != "failing" || runErr.Stage != "post" {
+		t.Errorf("expected only the failing account to fail at the post stage, got %+v", runErr)
+	}
+	if fixture.downloads != 1 {
+		t.Errorf("expected the potd to be downloaded once, got %d downloads", fixture.downloads)
+	}
+	if len(fixture.uploads["failing"]) != 0 || len(fixture.uploads["fresh"]) != 1 {
+		t.Fatalf("expected a single upload by the fresh account, got %v", fixture.uploads)
+	}
+
+	fresh, ok, err := history.Get(date, "fresh")
+	if err != nil || !ok || !fresh.Complete || len(fresh.PostIds) < 2 || fresh.PostIds[0] != fixture.tweets["fresh"][0] {
+		t.Errorf("expected the thread of the fresh account to be recorded as complete, got %+v (err %v)", fresh, err)
+	}
+	resumed, ok, err := history.Get(date, "resumed")
+	if err != nil || !ok || !resumed.Complete || len(resumed.PostIds) != len(fresh.PostIds) || resumed.PostIds[0] != "1" {
+		t.Errorf("expected the interrupted thread to be completed, got %+v (err %v)", resumed, err)
+	}
+	if len(fixture.uploads["resumed"]) != 0 || len(fixture.tweets["resumed"]) != 0 || len(fixture.replies["resumed"]) == 0 || fixture.replies["resumed"][0] != "1" {
+		t.Errorf("expected the interrupted thread to be continued with replies only, got uploads %v, tweets %v and replies %v",
+			fixture.uploads["resumed"], fixture.tweets["resumed"], fixture.replies["resumed"])
+	}
+	skipped, ok, err := history.Get(date, "portrait")
+	if err != nil || !ok || !skipped.Complete || skipped.Skipped != "the picture is landscape" || len(skipped.PostIds) != 0 {
+		t.Errorf("expected the filtered account to be recorded as skipped, got %+v (err %v)", skipped, err)
+	}
+	if failed, ok, err := history.Get(date, "failing"); err != nil || (ok && failed.Complete) {
+		t.Errorf("expected the failing account not to be complete, got %+v (err %v)", failed, err)
+	}
+
+	fixture.failing["failing"] = false
+	if err := runOnce(context.Background(), config, history, date); err != nil {
+		t.Fatalf("expected the failed account to post on the next run, got %v", err)
+	}
+	if fixture.downloads != 1 {
+		t.Errorf("expected the cached potd to be used again, got %d downloads", fixture.downloads)
+	}
+	// the image was compressed once, so every account uploads the same file
+	if len(fixture.uploads["failing"]) != 1 || fixture.uploads["failing"][0] != fixture.uploads["fresh"][0] {
+		t.Errorf("expected the failed account to upload the same compressed file, got %v", fixture.uploads)
+	}
+	if len(fixture.tweets["fresh"]) != 1 || len(fixture.tweets["resumed"]) != 0 || len(fixture.tweets["failing"]) != 1 {
+		t.Errorf("expected each account to have posted one thread, got %v", fixture.tweets)
+	}
+	if done, err := history.Done(date, config.Accounts); err != nil || !done {
+		t.Errorf("expected every account to be done (err %v)", err)
+	}
+}
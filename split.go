@@ -0,0 +1,426 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	log "github.com/sirupsen/logrus"
+)
+
+// BreakKind classifies the boundary between two consecutive words by how cleanly a thread can be split there.
+type BreakKind int
+
+const (
+	// WordBreak is a boundary inside a clause, where a split is marked with ellipses.
+	WordBreak BreakKind = iota
+	// ClauseBreak follows a comma, semicolon, colon or dash.
+	ClauseBreak
+	// SentenceBreak follows the end of a sentence.
+	SentenceBreak
+)
+
+// the costs which the splitter minimises: every tweet in the thread, and every split other than between sentences
+const (
+	tweetCost       = 20
+	clauseBreakCost = 4
+	wordBreakCost   = 12
+)
+
+func (kind BreakKind) cost() int {
+	switch kind {
+	case SentenceBreak:
+		return 0
+	case ClauseBreak:
+		return clauseBreakCost
+	default:
+		return wordBreakCost
+	}
+}
+
+// abbreviations which end in a full stop without ending a sentence, compared in lower case without the full stop
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "st": true, "mt": true, "jr": true, "sr": true,
+	"e.g": true, "i.e": true, "etc": true, "vs": true, "cf": true, "ca": true, "c": true, "approx": true, "no": true,
+}
+
+// word is a unit of text which the splitter does not divide further.
+type word struct {
+	text string
+	// joined words follow the previous word directly, without a space in between
+	joined bool
+	// cut words are pieces of a longer word which did not fit into a tweet, so the boundary before them is not
+	// a boundary between words at all
+	cut bool
+}
+
+// noSpaceScripts are written without spaces between words, so text in them is divided at line break opportunities.
+var noSpaceScripts = []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai, unicode.Lao, unicode.Khmer, unicode.Myanmar}
+
+// segmentWords divides text into words at spaces, and further at the line break opportunities of the Unicode line
+// breaking algorithm within runs of scripts which do not use spaces, such as Chinese and Japanese.
+func segmentWords(text string) []word {
+	var words []word
+	for _, field := range strings.Fields(text) {
+		if !strings.ContainsFunc(field, func(r rune) bool { return unicode.In(r, noSpaceScripts...) }) {
+			words = append(words, word{text: field})
+			continue
+		}
+		joined := false
+		state := -1
+		for rest := field; rest != ""; {
+			var segment string
+			segment, rest, _, state = uniseg.FirstLineSegmentInString(rest, state)
+			words = append(words, word{text: segment, joined: joined})
+			joined = true
+		}
+	}
+	return words
+}
+
+// cutWords divides any word longer than budget into pieces which fit, between grapheme clusters so that no
+// character or emoji sequence is broken apart.
+func cutWords(words []word, rule LengthRule, budget int) []word {
+	var result []word
+	for _, w := range words {
+		if rule.Length(w.text) <= budget {
+			result = append(result, w)
+			continue
+		}
+		log.WithFields(log.Fields{"longWord": w.text, "budget": budget}).Info("cutting word which cannot fit into a tweet by itself")
+		piece := word{joined: w.joined, cut: w.cut}
+		graphemes := uniseg.NewGraphemes(w.text)
+		for graphemes.Next() {
+			cluster := graphemes.Str()
+			if rule.Length(piece.text+cluster) <= budget {
+				piece.text += cluster
+				continue
+			}
+			if piece.text == "" {
+				log.WithFields(log.Fields{"grapheme": cluster, "budget": budget}).Panic("grapheme cluster cannot fit into a tweet by itself")
+			}
+			result = append(result, piece)
+			piece = word{text: cluster, joined: true, cut: true}
+		}
+		result = append(result, piece)
+	}
+	return result
+}
+
+func tweetFromSlice(words []word) string {
+	var builder strings.Builder
+	for i, w := range words {
+		if i > 0 && !w.joined {
+			builder.WriteByte(' ')
+		}
+		builder.WriteString(w.text)
+	}
+	return builder.String()
+}
+
+// breakAfter classifies the boundary between a word and the word which follows it.
+func breakAfter(word string, next string) BreakKind {
+	// look past closing quotes and brackets to find the punctuation which ends the word
+	trimmed := strings.TrimRightFunc(word, func(r rune) bool {
+		return strings.ContainsRune("\"')]}»”’*_」』）", r)
+	})
+	// punctuation on its own does not delimit anything
+	if !strings.ContainsFunc(trimmed, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+		return WordBreak
+	}
+
+	switch last, _ := utf8.DecodeLastRuneInString(trimmed); {
+	case last == '。' || last == '！' || last == '？':
+		// full-width punctuation ends a sentence regardless of what follows, as these scripts have no capitals
+		return SentenceBreak
+	case last == '、' || last == '，' || last == '；' || last == '：':
+		return ClauseBreak
+	case last == '.' || last == '!' || last == '?':
+		stem := strings.ToLower(strings.TrimLeftFunc(strings.TrimSuffix(trimmed, "."), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}))
+		// initials such as "M. e. erythrocephala" and common abbreviations do not end sentences
+		if last == '.' && (len([]rune(stem)) <= 1 || abbreviations[stem]) {
+			return WordBreak
+		}
+		// a new sentence starts with a capital letter, a digit or an opening quote or bracket
+		first := []rune(next)[0]
+		if unicode.IsUpper(first) || unicode.IsDigit(first) || strings.ContainsRune("\"'([«“‘", first) {
+			return SentenceBreak
+		}
+		return ClauseBreak
+	case last == ',' || last == ';' || last == ':':
+		return ClauseBreak
+	}
+	if word == "—" || word == "–" || next == "—" || next == "–" {
+		return ClauseBreak
+	}
+	return WordBreak
+}
+
+// ContinuationStyle describes how the tweets of a thread are marked as continuing one another.
+type ContinuationStyle struct {
+	// Ellipses marks splits between words with a trailing "..." on one tweet and a leading one on the next.
+	Ellipses bool
+	// Prefix and Suffix are added to every tweet of a thread of more than one tweet,
+	// with {n} replaced by the position of the tweet and {total} by the length of the thread.
+	Prefix string
+	Suffix string
+}
+
+// The predefined continuation styles.
+var (
+	EllipsisStyle = ContinuationStyle{Ellipses: true}
+	CounterStyle  = ContinuationStyle{Suffix: " {n}/{total}"}
+	ThreadStyle   = ContinuationStyle{Prefix: "🧵 ", Suffix: " {n}/{total}"}
+)
+
+// continuationStyles maps the names accepted on the command line to the predefined styles.
+var continuationStyles = map[string]ContinuationStyle{
+	"ellipsis": EllipsisStyle,
+	"counter":  CounterStyle,
+	"thread":   ThreadStyle,
+}
+
+// hasMarkers reports whether the style adds a prefix or suffix to each tweet.
+func (style ContinuationStyle) hasMarkers() bool {
+	return style.Prefix != "" || style.Suffix != ""
+}
+
+// mark adds the prefix and suffix for the nth of total tweets.
+func (style ContinuationStyle) mark(tweet string, n int, total int) string {
+	replacer := strings.NewReplacer("{n}", strconv.Itoa(n), "{total}", strconv.Itoa(total))
+	return replacer.Replace(style.Prefix) + tweet + replacer.Replace(style.Suffix)
+}
+
+// maxMarkerIterations bounds the search for a consistent thread length when markers include it.
+const maxMarkerIterations = 10
+
+// TruncateTweetBody splits text into a thread of valid tweets, marking continuations with ellipses.
+func TruncateTweetBody(text string) []string {
+	return SplitThread(text, EllipsisStyle, TwitterRule)
+}
+
+// SplitThread splits text into a thread of posts which each fit within the limit of the length rule. Splits are placed preferably between sentences,
+// then between clauses, and only otherwise between words. Among all possible threads, the one minimising a cost
+// which adds up a fixed amount per tweet and a penalty per split, depending on its kind, is chosen.
+//
+// Markers containing the thread length take up space which depends on that length, so the thread is split assuming
+// a length, and split again with the length which resulted until it is no longer than the one assumed.
+// Every tweet reserves room for the widest marker, that of the final tweet of a thread of the assumed length.
+func SplitThread(text string, style ContinuationStyle, rule LengthRule) []string {
+	return SplitThreadWithLink(text, style, rule, "", LinkNone)
+}
+
+// LinkPlacement says where a link is put in a thread.
+type LinkPlacement int
+
+const (
+	// LinkNone leaves the link out.
+	LinkNone LinkPlacement = iota
+	// LinkFirst adds the link to the end of the first tweet.
+	LinkFirst
+	// LinkLast adds the link as a tweet of its own at the end of the thread.
+	LinkLast
+)
+
+// linkPlacements maps the names accepted on the command line to link placements.
+var linkPlacements = map[string]LinkPlacement{
+	"none":  LinkNone,
+	"first": LinkFirst,
+	"last":  LinkLast,
+}
+
+// SplitThreadWithLink splits text as SplitThread does, and adds a link to it in the given place.
+// A link in the first tweet is budgeted for with the length the rule gives it, such as 23 for a t.co link on Twitter.
+func SplitThreadWithLink(text string, style ContinuationStyle, rule LengthRule, link string, placement LinkPlacement) []string {
+	log.WithFields(log.Fields{"textInput": text, "style": style, "lengthRule": rule.Name(), "link": link, "linkPlacement": placement}).Info("starting to truncate text")
+
+	if link == "" {
+		placement = LinkNone
+	}
+	words := segmentWords(text)
+	if len(words) == 0 {
+		if placement != LinkNone {
+			return verifyTweets([]string{link}, rule)
+		}
+		return []string{""}
+	}
+
+	firstReserve := 0
+	if placement == LinkFirst {
+		firstReserve = rule.Length(" " + link)
+	}
+	split := func(reserve int) []string {
+		tweets := splitWords(words, style.Ellipses, rule, reserve, firstReserve)
+		switch placement {
+		case LinkFirst:
+			tweets[0] += " " + link
+		case LinkLast:
+			tweets = append(tweets, link)
+		}
+		return tweets
+	}
+
+	// a thread which fits in a single tweet needs no markers at all
+	unmarked := split(0)
+	if len(unmarked) == 1 || !style.hasMarkers() {
+		return verifyTweets(unmarked, rule)
+	}
+
+	var tweets []string
+	for assumed, iteration := len(unmarked), 0; ; iteration++ {
+		tweets = split(rule.Length(style.mark("", assumed, assumed)))
+		log.WithFields(log.Fields{"assumedTotal": assumed, "actualTotal": len(tweets)}).Info("split thread with markers")
+		if len(tweets) <= assumed {
+			break
+		}
+		if iteration == maxMarkerIterations {
+			log.WithFields(log.Fields{"assumedTotal": assumed, "actualTotal": len(tweets)}).Panic("could not find a consistent thread length for markers")
+		}
+		assumed = len(tweets)
+	}
+
+	// a shorter thread than assumed only has narrower markers, so every tweet remains valid
+	for i := range tweets {
+		tweets[i] = style.mark(tweets[i], i+1, len(tweets))
+	}
+
+	log.WithField("allTweets", tweets).Info("finished marking tweets")
+
+	return verifyTweets(tweets, rule)
+}
+
+// verifyTweets measures each finished tweet in full, in case summing the lengths of its words was inaccurate.
+func verifyTweets(tweets []string, rule LengthRule) []string {
+	for _, tweet := range tweets {
+		if length := rule.Length(tweet); length > rule.Limit() {
+			log.WithFields(log.Fields{"tweet": tweet, "length": length, "limit": rule.Limit()}).Panic("generated tweet is too long")
+		}
+	}
+	return tweets
+}
+
+// splitWords finds the lowest cost thread of words, where reserve is the length set aside in every tweet for markers,
+// and firstReserve is the length set aside in addition in the first tweet. The returned tweets include ellipses if requested, but not markers. Words which cannot fit into a tweet by
+// themselves are cut into pieces first.
+//
+// Measuring a whole tweet with twitter-text takes time quadratic in its length, so instead each word is measured once,
+// and the length of a tweet is taken to be the sum of its words, the spaces between them and any ellipses.
+func splitWords(words []word, ellipses bool, rule LengthRule, reserve int, firstReserve int) []string {
+	const ellipsis = "..."
+	ellipsisLength := rule.Length(ellipsis)
+	spaceLength := rule.Length(" ")
+
+	// a piece may need ellipses on both sides, and may have to fit alongside whatever the first tweet reserves
+	budget := rule.Limit() - reserve - firstReserve
+	if ellipses {
+		budget -= 2 * ellipsisLength
+	}
+	words = cutWords(words, rule, budget)
+
+	// breaks[i] is the kind of the boundary before words[i], for 0 < i < len(words)
+	breaks := make([]BreakKind, len(words))
+	for i := 1; i < len(words); i++ {
+		if !words[i].cut {
+			breaks[i] = breakAfter(words[i-1].text, words[i].text)
+		}
+	}
+
+	// cumulative[i] is the total length of words[:i], and spaces[i] is the number of spaces before words[1:i]
+	cumulative := make([]int, len(words)+1)
+	spaces := make([]int, len(words)+1)
+	for i, w := range words {
+		cumulative[i+1] = cumulative[i] + rule.Length(w.text)
+		spaces[i+1] = spaces[i]
+		if i > 0 && !w.joined {
+			spaces[i+1]++
+		}
+	}
+
+	hasLeadingEllipsis := func(start int) bool {
+		return ellipses && start > 0 && breaks[start] == WordBreak
+	}
+	hasTrailingEllipsis := func(end int) bool {
+		return ellipses && end < len(words) && breaks[end] == WordBreak
+	}
+
+	// tweetText renders the tweet consisting of words[start:end], with ellipses where it continues a split mid-clause
+	tweetText := func(start int, end int) string {
+		tweet := tweetFromSlice(words[start:end])
+		if hasLeadingEllipsis(start) {
+			tweet = ellipsis + tweet
+		}
+		if hasTrailingEllipsis(end) {
+			tweet += ellipsis
+		}
+		return tweet
+	}
+	tweetLength := func(start int, end int) int {
+		length := cumulative[end] - cumulative[start] + (spaces[end]-spaces[start+1])*spaceLength + reserve
+		if start == 0 {
+			length += firstReserve
+		}
+		if hasLeadingEllipsis(start) {
+			length += ellipsisLength
+		}
+		if hasTrailingEllipsis(end) {
+			length += ellipsisLength
+		}
+		return length
+	}
+
+	// best[i] is the lowest cost of a thread covering words[:i], and previous[i] is where its final tweet starts
+	const unreachable = -1
+	best := make([]int, len(words)+1)
+	previous := make([]int, len(words)+1)
+	for i := 1; i <= len(words); i++ {
+		best[i] = unreachable
+	}
+
+	for start := 0; start < len(words); start++ {
+		if best[start] == unreachable {
+			continue
+		}
+		for end := start + 1; end <= len(words); end++ {
+			length := tweetLength(start, end)
+			trailing := 0
+			if hasTrailingEllipsis(end) {
+				trailing = ellipsisLength
+			}
+			// ignoring the trailing ellipsis, tweets only get longer as words are added
+			if length-trailing > rule.Limit() {
+				break
+			}
+			if length > rule.Limit() {
+				continue
+			}
+
+			cost := best[start] + tweetCost
+			if end < len(words) {
+				cost += breaks[end].cost()
+			}
+			// on a tie, prefer the later start, so that earlier tweets are filled as far as possible
+			if best[end] == unreachable || cost <= best[end] {
+				best[end] = cost
+				previous[end] = start
+			}
+		}
+	}
+
+	if best[len(words)] == unreachable {
+		log.WithField("words", words).Panic("could not split text into tweets within the length limit")
+	}
+
+	// walk back from the end to recover the chosen tweets
+	var allTweets []string
+	for end := len(words); end > 0; end = previous[end] {
+		allTweets = append([]string{tweetText(previous[end], end)}, allTweets...)
+	}
+
+	log.WithFields(log.Fields{"allTweets": allTweets, "cost": best[len(words)]}).Info("finished generating tweets")
+
+	return allTweets
+}
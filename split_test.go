@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBreakAfter(t *testing.T) {
+	cases := []struct {
+		word     string
+		next     string
+		expected BreakKind
+	}{
+		{"USA.", "These", SentenceBreak},
+		{"up.", "The", SentenceBreak},
+		{"1840.", "Two", SentenceBreak},
+		{"it?\"", "She", SentenceBreak},
+		{"(4.7", "in),", WordBreak},
+		{"in),", "it", ClauseBreak},
+		{"dimorphic;", "the", ClauseBreak},
+		{"M.", "e.", WordBreak},
+		{"e.", "erythrocephala", WordBreak},
+		{"Dr.", "Smith", WordBreak},
+		{"etc.", "and", WordBreak},
+		{"end.", "lowercase", ClauseBreak},
+		{",,,", ",,,", WordBreak},
+		{"tower", "—", ClauseBreak},
+		{"tree", "in", WordBreak},
+	}
+	for _, c := range cases {
+		if got := breakAfter(c.word, c.next); got != c.expected {
+			t.Errorf("breakAfter(%q, %q) = %d, expected %d", c.word, c.next, got, c.expected)
+		}
+	}
+}
+
+// A single sentence too long for one tweet is split at a comma, without ellipses.
+func TestTruncatePrefersClauses(t *testing.T) {
+	text := "The Broadway Tower is a folly on Broadway Hill, near the large village of Broadway, in the English county of Worcestershire, at the second highest point of the Cotswolds, and was built in 1798 for Lady Coventry to a design by James Wyatt, in the form of a castle, and it was later home to the printing press of Sir Thomas Phillipps, who collected manuscripts, and to William Morris, who holidayed there"
+	expected := []string{"The Broadway Tower is a folly on Broadway Hill, near the large village of Broadway, in the English county of Worcestershire, at the second highest point of the Cotswolds, and was built in 1798 for Lady Coventry to a design by James Wyatt, in the form of a castle,",
+		"and it was later home to the printing press of Sir Thomas Phillipps, who collected manuscripts, and to William Morris, who holidayed there"}
+	if got := TruncateTweetBody(text); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+// Without any punctuation to split at, the splitter falls back to words and ellipses.
+func TestTruncateFallsBackToWords(t *testing.T) {
+	text := "Looking down the valley from the summit we see the river winding its way between the meadows and the old stone walls that border the fields on either side of the water and beyond them the hills rising towards the distant moorland where sheep graze through the long summer days while the farmers bring in the hay before the autumn rains arrive to soak the land. Then winter."
+	expected := []string{"Looking down the valley from the summit we see the river winding its way between the meadows and the old stone walls that border the fields on either side of the water and beyond them the hills rising towards the distant moorland where sheep graze through the long summer days...",
+		"...while the farmers bring in the hay before the autumn rains arrive to soak the land. Then winter."}
+	if got := TruncateTweetBody(text); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSplitThreadCounters(t *testing.T) {
+	// short text is left unmarked
+	if got := SplitThread("A single tweet.", CounterStyle, TwitterRule); !reflect.DeepEqual(got, []string{"A single tweet."}) {
+		t.Errorf("expected unmarked single tweet, got %q", got)
+	}
+
+	// each counter takes up space, and the total is known from the start
+	text := strings.Repeat("word ", 600)
+	got := SplitThread(text, CounterStyle, TwitterRule)
+	for i, tweet := range got {
+		suffix := fmt.Sprintf(" %d/%d", i+1, len(got))
+		if !strings.HasSuffix(tweet, suffix) || strings.Contains(tweet, "...") || TwitterRule.Length(tweet) > TwitterRule.Limit() {
+			t.Errorf("tweet %d is not a valid tweet ending in %q: %q", i, suffix, tweet)
+		}
+	}
+	// 600 five character words need eleven tweets of at most 280 characters
+	if len(got) != 11 {
+		t.Errorf("expected 11 tweets, got %d", len(got))
+	}
+
+	custom := ContinuationStyle{Prefix: "[{n} of {total}] ", Ellipses: true}
+	got = SplitThread(text, custom, TwitterRule)
+	if !strings.HasPrefix(got[0], "[1 of ") || !strings.HasSuffix(got[0], "...") || !strings.HasPrefix(got[1], "[2 of ") {
+		t.Errorf("expected custom prefix and ellipses, got %q", got[:2])
+	}
+}
+
+// Test that a word too long for a tweet is cut between grapheme clusters instead of causing a panic.
+func TestSplitThreadCutsLongWords(t *testing.T) {
+	for _, rule := range allRules {
+		family := "👨‍👩‍👧‍👦"
+		text := "Family: " + strings.Repeat(family, rule.Limit())
+		got := SplitThread(text, EllipsisStyle, rule)
+		if len(got) < 2 {
+			t.Errorf("%s: expected the emoji to be split over several tweets, got %q", rule.Name(), got)
+		}
+		joined := strings.NewReplacer("...", "", " ", "").Replace(strings.Join(got, ""))
+		if joined != "Family:"+strings.Repeat(family, rule.Limit()) {
+			t.Errorf("%s: expected every emoji to be kept whole, got %q", rule.Name(), got)
+		}
+	}
+}
+
+// Test that text without spaces is split at line break opportunities, preferring the ends of sentences.
+func TestSplitThreadWithoutSpaces(t *testing.T) {
+	sentence := "富士山は日本で最も高い山であり、古くから多くの芸術作品に描かれてきた。"
+	text := strings.Repeat(sentence, 10)
+	got := SplitThread(text, EllipsisStyle, TwitterRule)
+	if len(got) < 2 {
+		t.Fatalf("expected several tweets, got %q", got)
+	}
+	for i, tweet := range got {
+		if !strings.HasSuffix(tweet, "。") && i < len(got)-1 {
+			t.Errorf("expected tweet %d to end a sentence, got %q", i, tweet)
+		}
+		if strings.Contains(tweet, " ") || strings.Contains(tweet, "...") {
+			t.Errorf("expected tweet %d to be split without spaces or ellipses, got %q", i, tweet)
+		}
+	}
+	if strings.Join(got, "") != text {
+		t.Errorf("expected the tweets to make up the original text, got %q", got)
+	}
+}
+
+func TestSegmentWords(t *testing.T) {
+	got := segmentWords("Mount Fuji 富士山です。")
+	expected := []word{{text: "Mount"}, {text: "Fuji"}, {text: "富"}, {text: "士", joined: true}, {text: "山", joined: true}, {text: "で", joined: true}, {text: "す。", joined: true}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSplitThreadWithLink(t *testing.T) {
+	link := "https://commons.wikimedia.org/wiki/File:Yellow-bellied_sapsucker_(Sphyrapicus_varius)_male_Central_Park,_NY.jpg"
+	text := strings.Repeat("word ", 100)
+
+	// the link counts as 23 characters, so the first tweet holds as many words as it would with a short link
+	got := SplitThreadWithLink(text, CounterStyle, TwitterRule, link, LinkFirst)
+	if !strings.HasSuffix(got[0], " "+link+" 1/"+fmt.Sprint(len(got))) {
+		t.Errorf("expected link at the end of the first tweet, got %q", got[0])
+	}
+	if TwitterRule.Length(got[0]) > TwitterRule.Limit() || TwitterRule.Length(got[0]) < TwitterRule.Limit()-5 {
+		t.Errorf("expected first tweet to be filled with the link budgeted as 23 characters, got length %d", TwitterRule.Length(got[0]))
+	}
+	if words := strings.Count(strings.Join(got, " "), "word"); words != 100 {
+		t.Errorf("expected every word to be kept, got %d", words)
+	}
+
+	// a link at the end is counted as part of the thread
+	got = SplitThreadWithLink("A single tweet.", CounterStyle, TwitterRule, link, LinkLast)
+	expected := []string{"A single tweet. 1/2", link + " 2/2"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	if got := SplitThreadWithLink("", EllipsisStyle, TwitterRule, link, LinkFirst); !reflect.DeepEqual(got, []string{link}) {
+		t.Errorf("expected only the link for an empty description, got %q", got)
+	}
+}
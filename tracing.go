@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the pipeline. Until setupTracing installs an exporter, its spans are discarded.
+var tracer = otel.Tracer("wikicommonspotd")
+
+// setupTracing exports spans to the configured OTLP endpoint and file, if any. The returned function flushes the
+// spans which have not been exported yet, and must be called before the program exits.
+func setupTracing(config TracingConfig) (func(context.Context) error, error) {
+	var options []sdktrace.TracerProviderOption
+	var file *os.File
+	if config.Endpoint != "" {
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(config.Endpoint))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	if config.File != "" {
+		if err := os.MkdirAll(filepath.Dir(config.File), 0755); err != nil {
+			return nil, err
+		}
+		var err error
+		file, err = os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		// spans are written as they end, so that the file is complete even if the program crashes
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		options = append(options, sdktrace.WithSyncer(exporter))
+	}
+	if len(options) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	service, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "wikicommonspotd")))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(append(options, sdktrace.WithResource(service))...)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// startSpan starts a span as a child of any span in ctx. The returned function ends it, and must be deferred so
+// that a panic, which is how the pipeline fails, is recorded as an error of the span before it carries on.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span, func()) {
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+	return ctx, span, func() {
+		if recovered := recover(); recovered != nil {
+			err := panicError(recovered)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			span.End()
+			panic(recovered)
+		}
+		span.End()
+	}
+}
+
+// tracingTransport traces requests made within a span, such as those to Wikimedia and the publishers. Requests
+// made outside any span are not traced, since they would each start a trace of their own.
+func tracingTransport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base, otelhttp.WithFilter(func(req *http.Request) bool {
+		return trace.SpanContextFromContext(req.Context()).IsValid()
+	}))
+}
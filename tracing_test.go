@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// exportedSpan holds the fields of a span written by the file exporter which the tests look at.
+type exportedSpan struct {
+	Name        string
+	SpanContext struct{ SpanID string }
+	Parent      struct{ SpanID string }
+	Status      struct{ Code string }
+}
+
+func TestTracingFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = tracingTransport(http.DefaultTransport)
+	defer func() { http.DefaultClient.Transport = transport }()
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := setupTracing(TracingConfig{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		ctx, _, end := startSpan(context.Background(), "run")
+		defer end()
+		file, err := os.CreateTemp(t.TempDir(), "potd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		downloadFile(ctx, file, server.URL, DownloadOptions{Timeout: time.Minute, MaxBytes: 1000, MaxAttempts: 1})
+	}()
+	func() {
+		defer func() { recover() }()
+		_, _, end := startSpan(context.Background(), "failing")
+		defer end()
+		log.WithError(errors.New("broken")).Panic("could not do it")
+	}()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	traces, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer traces.Close()
+	spans := map[string]exportedSpan{}
+	decoder := json.NewDecoder(traces)
+	for {
+		var span exportedSpan
+		if err := decoder.Decode(&span); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		spans[span.Name] = span
+	}
+
+	download, ok := spans["downloadFile"]
+	if !ok || download.Parent.SpanID != spans["run"].SpanContext.SpanID {
+		t.Errorf("expected a downloadFile span within the run, got %+v", spans)
+	}
+	request, ok := spans["HTTP GET"]
+	if !ok || request.Parent.SpanID != download.SpanContext.SpanID {
+		t.Errorf("expected the request to be traced within downloadFile, got %+v", spans)
+	}
+	if spans["failing"].Status.Code != "Error" {
+		t.Errorf("expected a panic to be recorded as an error, got %+v", spans["failing"])
+	}
+}